@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/picatz/roku"
+)
+
+// apiServer serves information about the Rokus over HTTP.
+type apiServer struct {
+	mu    sync.RWMutex
+	rokus map[string]*Roku // keyed by serial number
+
+	// token, if set, must be given as a bearer token for requests
+	// that change anything.  tokens are other tokens that can only do
+	// some things.
+	token  string
+	tokens map[string]tokenGrants
+
+	// tlsCert and tlsKey are files with the certificate and key to
+	// serve HTTPS with.  Plain HTTP is served if they're empty.
+	tlsCert string
+	tlsKey  string
+
+	pause *pauser
+}
+
+func newAPIServer() *apiServer {
+	return &apiServer{rokus: map[string]*Roku{}}
+}
+
+func (s *apiServer) add(r *Roku) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rokus[r.deviceInfo.SerialNumber] = r
+}
+
+func (s *apiServer) get(serial string) *Roku {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rokus[serial]
+}
+
+func (s *apiServer) list() []*Roku {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rokus := make([]*Roku, 0, len(s.rokus))
+	for _, r := range s.rokus {
+		rokus = append(rokus, r)
+	}
+	sort.Slice(rokus, func(i, j int) bool {
+		return rokus[i].deviceInfo.SerialNumber < rokus[j].deviceInfo.SerialNumber
+	})
+	return rokus
+}
+
+func (s *apiServer) listenAndServe(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", s.handleDevices)
+	mux.HandleFunc("/devices/", s.handleDevice)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/pause", s.handlePause)
+	mux.HandleFunc("/resume", s.handlePause)
+
+	var err error
+	if s.tlsCert != "" || s.tlsKey != "" {
+		log.Printf("Serving API on %s over HTTPS", addr)
+		err = http.ListenAndServeTLS(addr, s.tlsCert, s.tlsKey, s.authorize(mux))
+	} else {
+		log.Printf("Serving API on %s", addr)
+		err = http.ListenAndServe(addr, s.authorize(mux))
+	}
+	if err != nil {
+		log.Printf("API server: %v", err)
+	}
+}
+
+// authorize requires an API token, if there are any, for requests
+// other than GET and HEAD.  Requests with a limited token carry its
+// grants, for the handlers to check.
+func (s *apiServer) authorize(h http.Handler) http.Handler {
+	if s.token == "" && len(s.tokens) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			grants, full, ok := s.lookupToken(req)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !full {
+				req = withGrants(req, grants)
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+type deviceJSON struct {
+	Serial       string            `json:"serial"`
+	Name         string            `json:"name"`
+	Model        string            `json:"model"`
+	Firmware     string            `json:"firmware"`
+	Reachable    bool              `json:"reachable"`
+	Network      networkJSON       `json:"network"`
+	DeviceInfo   map[string]string `json:"device_info,omitempty"`
+	Reachability reachabilityJSON  `json:"reachability"`
+	SelfTest     *selfTestJSON     `json:"selftest,omitempty"`
+	LastCommand  *lastCommandJSON  `json:"last_command,omitempty"`
+}
+
+type networkJSON struct {
+	Type    string `json:"type"`
+	Name    string `json:"name,omitempty"`
+	MAC     string `json:"mac,omitempty"`
+	Address string `json:"address,omitempty"`
+	Port    int    `json:"port,omitempty"`
+}
+
+type selfTestJSON struct {
+	At        time.Time `json:"at"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type lastCommandJSON struct {
+	At      time.Time `json:"at"`
+	Source  string    `json:"source"`
+	Command string    `json:"command"`
+	Outcome string    `json:"outcome"`
+}
+
+type kioskJSON struct {
+	Running bool `json:"running"`
+}
+
+type inputSourceJSON struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Hidden bool   `json:"hidden"`
+}
+
+// inputPatchJSON changes an input's name, whether it's hidden, or both.
+type inputPatchJSON struct {
+	Name   *string `json:"name"`
+	Hidden *bool   `json:"hidden"`
+}
+
+type launchRequestJSON struct {
+	ContentID string `json:"contentID"`
+	MediaType string `json:"mediaType"`
+}
+
+type launchJSON struct {
+	App       *roku.App `json:"app"`
+	ActiveApp *roku.App `json:"active_app"`
+}
+
+// inputJSON is an ECP input event.  Params are passed to the app as
+// query parameters.
+type inputJSON struct {
+	Params map[string]string `json:"params"`
+	AppID  string            `json:"app_id,omitempty"`
+}
+
+type reachabilityJSON struct {
+	UnreachableAfter int `json:"unreachable_after"`
+	ReachableAfter   int `json:"reachable_after"`
+}
+
+func (r *Roku) toJSON() deviceJSON {
+	failure, recovery := r.reach.thresholds()
+
+	var st *selfTestJSON
+	if at, latency, err := r.selfTest.last(); !at.IsZero() {
+		st = &selfTestJSON{At: at, LatencyMS: latency.Milliseconds()}
+		if err != nil {
+			st.Error = err.Error()
+		}
+	}
+
+	var lc *lastCommandJSON
+	if e := r.latestCommand(); e != nil {
+		lc = &lastCommandJSON{At: e.Time, Source: e.Source, Command: e.Command, Outcome: e.Outcome}
+	}
+
+	info := r.currentDeviceInfo()
+
+	return deviceJSON{
+		Serial:    r.deviceInfo.SerialNumber,
+		Name:      r.deviceInfo.UserDeviceName,
+		Model:     info.ModelNumber,
+		Firmware:  accessoryFirmware(info),
+		Reachable: r.reach.isReachable(),
+		Network: networkJSON{
+			Type:    info.NetworkType,
+			Name:    info.NetworkName,
+			MAC:     macAddress(info),
+			Address: r.endpoint.host(),
+			Port:    endpointPort(r.endpoint),
+		},
+		DeviceInfo: deviceInfoValues(info, r.global.deviceInfoFields),
+		Reachability: reachabilityJSON{
+			UnreachableAfter: failure,
+			ReachableAfter:   recovery,
+		},
+		SelfTest:    st,
+		LastCommand: lc,
+	}
+}
+
+func (s *apiServer) handleDevices(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices := []deviceJSON{}
+	for _, r := range s.list() {
+		devices = append(devices, r.toJSON())
+	}
+
+	writeJSON(w, devices)
+}
+
+// handleDevice routes requests under /devices/{serial}.
+func (s *apiServer) handleDevice(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/devices/"), "/"), "/")
+
+	r := s.get(parts[0])
+	if r == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		writeJSON(w, r.toJSON())
+
+	case len(parts) == 2 && parts[1] == "apps" && req.Method == http.MethodGet:
+		if req.URL.Query().Get("refresh") != "" {
+			r.apps.invalidate()
+		}
+		apps, err := r.apps.get()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, apps)
+
+	case len(parts) == 4 && parts[1] == "apps" && parts[3] == "icon" && req.Method == http.MethodGet:
+		b, contentType, err := r.icon(parts[2])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "max-age=86400")
+		_, _ = w.Write(b)
+
+	case len(parts) == 3 && parts[1] == "launch" && req.Method == http.MethodPost:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capLaunch) {
+			return
+		}
+		var body launchRequestJSON
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params, err := deepLinkParams(body.ContentID, body.MediaType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.global.pause.isPaused() {
+			http.Error(w, "paused", http.StatusServiceUnavailable)
+			return
+		}
+
+		app, err := r.launch(parts[2], params)
+		r.audit(auditAPI, "launch "+parts[2], err)
+
+		var notInstalled *notInstalledError
+		switch {
+		case errors.As(err, &notInstalled) && notInstalled.installing:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			writeJSON(w, map[string]string{"app_id": notInstalled.id, "status": "install_prompted"})
+			return
+		case errors.As(err, &notInstalled):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := launchJSON{App: app}
+		if active, err := r.waitForActiveApp(app.ID); err != nil {
+			log.Printf("Couldn't get active app for %q after launch: %v", r.deviceInfo.UserDeviceName, err)
+		} else {
+			resp.ActiveApp = active
+		}
+		writeJSON(w, resp)
+
+	case len(parts) == 3 && parts[1] == "keypress" && req.Method == http.MethodPost:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capKeypress) {
+			return
+		}
+		key, err := rawKey(parts[2])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.global.pause.isPaused() {
+			http.Error(w, "paused", http.StatusServiceUnavailable)
+			return
+		}
+
+		err = r.endpoint.Keypress(key)
+		r.audit(auditAPI, "key "+key, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]string{"key": key})
+
+	case len(parts) == 2 && parts[1] == "input" && req.Method == http.MethodPost:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capInput) {
+			return
+		}
+		var body inputJSON
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body.Params) == 0 {
+			http.Error(w, "params must not be empty", http.StatusBadRequest)
+			return
+		}
+		if r.global.pause.isPaused() {
+			http.Error(w, "paused", http.StatusServiceUnavailable)
+			return
+		}
+
+		err := r.endpoint.Input(body.Params, body.AppID)
+		r.audit(auditAPI, "input", err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, body)
+
+	case len(parts) == 3 && parts[1] == "inputs" && req.Method == http.MethodPatch:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capRename) {
+			return
+		}
+		var body inputPatchJSON
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.Name == nil && body.Hidden == nil {
+			http.Error(w, "name or hidden must be given", http.StatusBadRequest)
+			return
+		}
+
+		id := parts[2]
+		var changes []string
+		if body.Name != nil {
+			name := strings.TrimSpace(*body.Name)
+			if name == "" {
+				http.Error(w, "name must not be empty", http.StatusBadRequest)
+				return
+			}
+			body.Name = &name
+			changes = append(changes, "rename input "+id)
+		}
+		if body.Hidden != nil {
+			changes = append(changes, fmt.Sprintf("hide input %s %t", id, *body.Hidden))
+		}
+
+		err := r.updateInput(id, body.Name, body.Hidden)
+		r.audit(auditAPI, strings.Join(changes, ", "), err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		input := r.inputSources[id]
+		writeJSON(w, inputSourceJSON{
+			ID:     id,
+			Name:   input.ConfiguredName.GetValue(),
+			Hidden: input.CurrentVisibilityState.GetValue() == characteristic.CurrentVisibilityStateHidden,
+		})
+
+	case len(parts) == 2 && parts[1] == "kiosk" && req.Method == http.MethodGet:
+		if r.cfg.Kiosk == nil {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, kioskJSON{Running: r.kioskRunning()})
+
+	case len(parts) == 2 && parts[1] == "kiosk" && req.Method == http.MethodPut:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capKiosk) {
+			return
+		}
+		if r.cfg.Kiosk == nil {
+			http.NotFound(w, req)
+			return
+		}
+		var body kioskJSON
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.Running {
+			r.startKiosk()
+		} else {
+			r.stopKiosk()
+		}
+		r.audit(auditAPI, fmt.Sprintf("kiosk running=%t", body.Running), nil)
+		writeJSON(w, body)
+
+	case len(parts) == 2 && parts[1] == "reachability" && req.Method == http.MethodPut:
+		if !s.allowed(w, req, r.deviceInfo.SerialNumber, capReachability) {
+			return
+		}
+		var body reachabilityJSON
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.UnreachableAfter < 1 || body.ReachableAfter < 1 {
+			http.Error(w, "thresholds must be at least 1", http.StatusBadRequest)
+			return
+		}
+		r.setReachabilityThresholds(body.UnreachableAfter, body.ReachableAfter)
+		r.audit(auditAPI, fmt.Sprintf("reachability %d/%d", body.UnreachableAfter, body.ReachableAfter), nil)
+		writeJSON(w, body)
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+type pauseJSON struct {
+	Paused bool `json:"paused"`
+	Queued int  `json:"queued"`
+}
+
+// handlePause pauses or resumes polling and commands for every Roku,
+// and reports whether they're paused.
+func (s *apiServer) handlePause(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := s.pause
+	if req.Method == http.MethodPost {
+		if !s.allowed(w, req, allDevices, capPause) {
+			return
+		}
+		if req.URL.Path == "/pause" {
+			p.pause()
+		} else {
+			p.resume()
+		}
+	}
+
+	paused, queued := p.status()
+	writeJSON(w, pauseJSON{Paused: paused, Queued: queued})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
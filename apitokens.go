@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Capabilities that limited API tokens can be granted.
+const (
+	capLaunch       = "launch"
+	capKeypress     = "keypress"
+	capInput        = "input"
+	capRename       = "rename"
+	capKiosk        = "kiosk"
+	capReachability = "reachability"
+	capPause        = "pause"
+)
+
+var capabilities = []string{capLaunch, capKeypress, capInput, capRename, capKiosk, capReachability, capPause}
+
+// allDevices stands for every Roku in a token's grants.
+const allDevices = "*"
+
+// tokenGrants maps serial numbers, or allDevices, to the capabilities
+// a limited API token has for those Rokus.  Pausing affects all of
+// them, so capPause only counts under allDevices.
+type tokenGrants map[string][]string
+
+// loadAPITokens reads limited API tokens from a JSON file mapping each
+// token to its grants.
+func loadAPITokens(path string) (map[string]tokenGrants, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read API tokens: %w", err)
+	}
+
+	var tokens map[string]tokenGrants
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("unable to parse API tokens %s: %w", path, err)
+	}
+
+	for _, grants := range tokens {
+		for _, caps := range grants {
+			for _, c := range caps {
+				if !isCapability(c) {
+					return nil, fmt.Errorf("unknown capability %q in %s (known: %s)", c, path, strings.Join(capabilities, ", "))
+				}
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+func isCapability(c string) bool {
+	for _, k := range capabilities {
+		if c == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (g tokenGrants) allows(serial, capability string) bool {
+	for _, s := range []string{serial, allDevices} {
+		for _, c := range g[s] {
+			if c == capability {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type grantsKey struct{}
+
+// lookupToken returns the grants for the request's bearer token, and
+// whether it has full access.  ok is false if the token isn't valid.
+func (s *apiServer) lookupToken(req *http.Request) (grants tokenGrants, full, ok bool) {
+	got := []byte(req.Header.Get("Authorization"))
+	if s.token != "" && subtle.ConstantTimeCompare(got, []byte("Bearer "+s.token)) == 1 {
+		return nil, true, true
+	}
+	for token, g := range s.tokens {
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+token)) == 1 {
+			return g, false, true
+		}
+	}
+	return nil, false, false
+}
+
+// allowed reports whether the request may use capability on the Roku
+// with the given serial number, answering 403 Forbidden if not.  Only
+// requests made with a limited token are restricted.
+func (s *apiServer) allowed(w http.ResponseWriter, req *http.Request, serial, capability string) bool {
+	grants, ok := req.Context().Value(grantsKey{}).(tokenGrants)
+	if !ok || grants.allows(serial, capability) {
+		return true
+	}
+
+	http.Error(w, "forbidden", http.StatusForbidden)
+	return false
+}
+
+func withGrants(req *http.Request, g tokenGrants) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), grantsKey{}, g))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/picatz/roku"
+)
+
+// appCache holds a Roku's app list for up to ttl, so that everything
+// that needs it doesn't query the Roku separately.  Concurrent callers
+// that miss the cache share a single fetch.
+type appCache struct {
+	ttl   time.Duration
+	fetch func() (roku.Apps, error)
+
+	mu       sync.Mutex
+	apps     roku.Apps
+	fetched  time.Time
+	inflight *appFetch
+}
+
+type appFetch struct {
+	done chan struct{}
+	apps roku.Apps
+	err  error
+}
+
+func newAppCache(ttl time.Duration, fetch func() (roku.Apps, error)) *appCache {
+	return &appCache{ttl: ttl, fetch: fetch}
+}
+
+func (c *appCache) get() (roku.Apps, error) {
+	c.mu.Lock()
+
+	if c.apps != nil && time.Since(c.fetched) < c.ttl {
+		apps := c.apps
+		c.mu.Unlock()
+		return apps, nil
+	}
+
+	if f := c.inflight; f != nil {
+		c.mu.Unlock()
+		<-f.done
+		return f.apps, f.err
+	}
+
+	f := &appFetch{done: make(chan struct{})}
+	c.inflight = f
+	c.mu.Unlock()
+
+	f.apps, f.err = c.fetch()
+
+	c.mu.Lock()
+	c.inflight = nil
+	if f.err == nil {
+		c.apps = f.apps
+		c.fetched = time.Now()
+	}
+	c.mu.Unlock()
+
+	close(f.done)
+	return f.apps, f.err
+}
+
+// set stores apps fetched elsewhere.
+func (c *appCache) set(apps roku.Apps) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apps = apps
+	c.fetched = time.Now()
+}
+
+// cached returns the apps last fetched, even if they're stale, without
+// fetching them again.
+func (c *appCache) cached() roku.Apps {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.apps
+}
+
+// invalidate makes the next get fetch the app list from the Roku.
+func (c *appCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apps = nil
+}
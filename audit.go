@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sources of the commands recorded in the audit log.
+const (
+	auditHomeKit = "homekit"
+	auditAPI     = "api"
+	auditAutoOff = "auto_off"
+	auditKiosk   = "kiosk"
+)
+
+// auditLog records the commands sent to Rokus, one JSON object per
+// line, so that it's possible to work out later why a TV turned on.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Serial  string    `json:"serial"`
+	Name    string    `json:"name"`
+	Command string    `json:"command"`
+	Outcome string    `json:"outcome"`
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log: %w", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends an entry to the log.  It's safe to call on a nil
+// *auditLog, which records nothing.
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Unable to encode audit log entry: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		log.Printf("Unable to write audit log: %v", err)
+	}
+}
+
+// audit records a command sent to the Roku from source, and whether it
+// succeeded.
+func (r *Roku) audit(source, command string, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = err.Error()
+	}
+
+	e := auditEntry{
+		Time:    time.Now(),
+		Source:  source,
+		Serial:  r.deviceInfo.SerialNumber,
+		Name:    r.deviceInfo.UserDeviceName,
+		Command: command,
+		Outcome: outcome,
+	}
+
+	r.lastCommandMu.Lock()
+	r.lastCommand = &e
+	r.lastCommandMu.Unlock()
+
+	r.global.audit.record(e)
+}
+
+// latestCommand returns the most recent command sent to the Roku, or
+// nil if there hasn't been one since startup.
+func (r *Roku) latestCommand() *auditEntry {
+	r.lastCommandMu.Lock()
+	defer r.lastCommandMu.Unlock()
+	return r.lastCommand
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+)
+
+// autoOffRule turns a Roku off when one of Apps is exited to the home
+// screen, and the Roku stays there for After.  It's meant for kids'
+// TVs, so that leaving their app is the end of TV time.
+type autoOffRule struct {
+	Apps  []string `json:"apps"`
+	After string   `json:"after,omitempty"`
+	after time.Duration
+}
+
+func (rule *autoOffRule) validate() error {
+	if len(rule.Apps) == 0 {
+		return errors.New("apps must not be empty")
+	}
+
+	if rule.After != "" {
+		d, err := time.ParseDuration(rule.After)
+		if err != nil {
+			return fmt.Errorf("invalid after: %w", err)
+		}
+		if d < 0 {
+			return errors.New("after must not be negative")
+		}
+		rule.after = d
+	}
+
+	return nil
+}
+
+func (rule *autoOffRule) watches(appID string) bool {
+	for _, id := range rule.Apps {
+		if id == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAutoOff applies the Roku's auto_off rule, given the state just
+// polled.  It must be called before last is updated.  Since it only
+// sees polls, the Roku is turned off at the first poll after After has
+// passed.  Rokus with read-only power are never turned off.
+func (r *Roku) checkAutoOff(last *pollState, active, identifier int) {
+	rule := r.cfg.AutoOff
+	if rule == nil || r.cfg.PowerReadOnly {
+		return
+	}
+
+	if active != characteristic.ActiveActive || identifier != homeIdentifier {
+		last.homeSince = time.Time{}
+		return
+	}
+
+	if last.active == characteristic.ActiveActive && last.identifier != homeIdentifier && rule.watches(r.inputs[last.identifier]) {
+		last.homeSince = time.Now()
+	}
+
+	if last.homeSince.IsZero() || time.Since(last.homeSince) < rule.after {
+		return
+	}
+	last.homeSince = time.Time{}
+
+	log.Printf("%q went back to the home screen from an auto_off app; turning it off", r.deviceInfo.UserDeviceName)
+
+	key := r.powerKey(characteristic.ActiveInactive)
+	r.powerSent()
+	err := r.endpoint.Keypress(key)
+	r.audit(auditAutoOff, "power off", err)
+	if err != nil {
+		log.Printf("Keypress %q on %q: %v", key, r.deviceInfo.UserDeviceName, err)
+		return
+	}
+
+	go r.confirmPower(characteristic.ActiveInactive)
+}
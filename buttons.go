@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// addButton adds a switch to the accessory that calls fn when turned
+// on, and then turns itself back off, so that it behaves like a push
+// button in the Home app.  HomeKit's stateless programmable switches
+// can only report presses to HomeKit, not receive them, so they can't
+// be used for this.
+func (r *Roku) addButton(label string, fn func()) {
+	sw := service.NewSwitch()
+
+	name := characteristic.NewName()
+	name.SetValue(label)
+	sw.AddCharacteristic(name.Characteristic)
+
+	sw.On.OnValueRemoteUpdate(func(on bool) {
+		if !on {
+			return
+		}
+
+		if !r.whilePaused(label+" button", fn) {
+			fn()
+		}
+
+		time.AfterFunc(500*time.Millisecond, func() {
+			sw.On.SetValue(false)
+		})
+	})
+
+	r.accessory.AddService(sw.Service)
+}
+
+// addKeyButton adds a button that sends key to the Roku.
+func (r *Roku) addKeyButton(label, key string) {
+	r.addButton(label, func() {
+		err := r.endpoint.Keypress(key)
+		r.audit(auditHomeKit, "key "+key, err)
+		if err != nil {
+			log.Printf("Keypress %q on %q: %v", key, r.deviceInfo.UserDeviceName, err)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const commandsUsage = `Commands:
+  apps [serial]            print the apps installed on a Roku as JSON
+  launch [serial] <app>    launch an app by ID or name
+  keypress [serial] <key>  send any ECP key, even one not known here
+  ping [serial]            measure how long each Roku takes to answer ECP
+  export-config            print per-device settings and saved state as JSON
+  import-config <file>     write out settings and state from export-config
+  dump-config              print the settings in effect as JSON`
+
+// runCommand runs a subcommand given after the flags, rather than the
+// HomeKit service.  It returns the process exit code.
+func runCommand(cfg *config, fs *flag.FlagSet, args []string) int {
+	switch args[0] {
+	case "apps":
+		return runApps(cfg, args[1:])
+	case "launch":
+		return runLaunch(cfg, args[1:])
+	case "keypress":
+		return runKeypress(cfg, args[1:])
+	case "ping":
+		return runPing(cfg, args[1:])
+	case "export-config":
+		return runExportConfig(cfg, args[1:])
+	case "import-config":
+		return runImportConfig(cfg, args[1:])
+	case "dump-config":
+		return runDumpConfig(cfg, fs, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s\n", args[0], commandsUsage)
+		return 2
+	}
+}
+
+func runApps(cfg *config, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: roku-homekit apps [serial]")
+		return 2
+	}
+
+	var serial string
+	if len(args) == 1 {
+		serial = args[0]
+	}
+
+	found, err := discover(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	d, err := selectDevice(found, serial)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	apps, err := d.endpoint.Apps()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting apps for %q: %v\n", d.deviceInfo.UserDeviceName, err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(apps); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func runLaunch(cfg *config, args []string) int {
+	var serial, query string
+	switch len(args) {
+	case 1:
+		query = args[0]
+	case 2:
+		serial, query = args[0], args[1]
+	default:
+		fmt.Fprintln(os.Stderr, "usage: roku-homekit launch [serial] <app>")
+		return 2
+	}
+
+	found, err := discover(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	d, err := selectDevice(found, serial)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	apps, err := d.endpoint.Apps()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting apps for %q: %v\n", d.deviceInfo.UserDeviceName, err)
+		return 1
+	}
+
+	app, err := resolveApp(apps, query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := d.endpoint.LaunchApp(app.ID, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't launch %s (%s) on %q: %v\n", app.Name, app.ID, d.deviceInfo.UserDeviceName, err)
+		return 1
+	}
+
+	fmt.Printf("Launched %s (%s) on %q\n", app.Name, app.ID, d.deviceInfo.UserDeviceName)
+	return 0
+}
+
+func runKeypress(cfg *config, args []string) int {
+	var serial, key string
+	switch len(args) {
+	case 1:
+		key = args[0]
+	case 2:
+		serial, key = args[0], args[1]
+	default:
+		fmt.Fprintln(os.Stderr, "usage: roku-homekit keypress [serial] <key>")
+		return 2
+	}
+
+	key, err := rawKey(key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	found, err := discover(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	d, err := selectDevice(found, serial)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := d.endpoint.Keypress(key); err != nil {
+		fmt.Fprintf(os.Stderr, "Keypress %q on %q: %v\n", key, d.deviceInfo.UserDeviceName, err)
+		return 1
+	}
+
+	fmt.Printf("Sent %q to %q\n", key, d.deviceInfo.UserDeviceName)
+	return 0
+}
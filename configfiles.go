@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3"
+)
+
+// configFiles expands -config, a comma-separated list of config files
+// and directories, into the files to read in order.  A directory's
+// files are read in name order; hidden files and subdirectories are
+// skipped.
+func configFiles(s string) ([]string, error) {
+	var files []string
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// parseConfigFiles sets flags from the given config files, in ff's
+// plain format.  Later files override earlier ones, but flags already
+// set on the command line or in the environment are left alone.
+func parseConfigFiles(fs *flag.FlagSet, files []string) error {
+	provided := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		provided[f.Name] = true
+	})
+
+	for _, path := range files {
+		if err := parseConfigFile(fs, path, provided); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseConfigFile(fs *flag.FlagSet, path string, provided map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ff.PlainParser(f, func(name, value string) error {
+		if provided[name] {
+			return nil
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: flag %q not defined", path, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: error setting flag %q: %w", path, name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// powerCooldown holds back power commands from HomeKit that come too
+// soon after the last power key was sent, since rapidly cycling power
+// can leave some TVs in a strange state.  Only the latest held-back
+// command is sent, once the cooldown is over.
+type powerCooldown struct {
+	mu      sync.Mutex
+	last    time.Time
+	pending int
+	timer   *time.Timer
+}
+
+func (r *Roku) powerCooldownDuration() time.Duration {
+	if r.cfg.powerCooldown != nil {
+		return *r.cfg.powerCooldown
+	}
+	return r.global.powerCooldown
+}
+
+// holdPower reports whether a power command should wait for the
+// cooldown to end.  If it should, it's sent when it does, unless
+// another command replaces it in the meantime.
+func (r *Roku) holdPower(active int) bool {
+	d := r.powerCooldownDuration()
+	if d <= 0 {
+		return false
+	}
+
+	c := &r.cooldown
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wait := d - time.Since(c.last)
+	if wait <= 0 {
+		c.last = time.Now()
+		return false
+	}
+
+	log.Printf("Holding power %s for %q for %s: power was changed too recently", powerString(active), r.deviceInfo.UserDeviceName, wait.Round(time.Millisecond))
+	c.pending = active
+	if c.timer == nil {
+		c.timer = time.AfterFunc(wait, r.endCooldown)
+	}
+	return true
+}
+
+// powerReady reports whether a power key can be sent now, starting the
+// cooldown if so.  Unlike holdPower, it doesn't hold the command back
+// to send later; the caller drops it.
+func (r *Roku) powerReady() bool {
+	d := r.powerCooldownDuration()
+	if d <= 0 {
+		return true
+	}
+
+	c := &r.cooldown
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.last) < d {
+		return false
+	}
+	c.last = time.Now()
+	return true
+}
+
+// powerSent starts the cooldown for a power key sent without asking
+// holdPower first, such as by auto_off.
+func (r *Roku) powerSent() {
+	c := &r.cooldown
+	c.mu.Lock()
+	c.last = time.Now()
+	c.mu.Unlock()
+}
+
+// endCooldown sends the power command held back during the cooldown,
+// if the Roku isn't already in that state.
+func (r *Roku) endCooldown() {
+	c := &r.cooldown
+	c.mu.Lock()
+	active := c.pending
+	c.timer = nil
+	c.mu.Unlock()
+
+	if r.getActive() == active {
+		log.Printf("Not sending held power %s for %q: it's already %s", powerString(active), r.deviceInfo.UserDeviceName, powerString(active))
+		return
+	}
+	r.setActive(active)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+
+	"github.com/picatz/roku"
+)
+
+// A sideloaded developer channel is listed with the app ID "dev",
+// whatever the channel is.  Its input is named devChannelName.
+const (
+	devChannelID   = "dev"
+	devChannelName = "Dev Channel"
+)
+
+// withDevChannel returns apps with the developer channel given a fixed
+// name, so that its input keeps its identifier, and HomeKit scenes
+// using it keep working, whichever channel is sideloaded.  On Rokus in
+// developer mode, the input is added even when nothing is sideloaded
+// yet, since restarting roku-homekit after every sideload would be
+// tedious.
+func withDevChannel(deviceInfo *roku.DeviceInfo, apps roku.Apps) roku.Apps {
+	devMode := deviceInfo.DeveloperEnabled == "true"
+
+	out := make(roku.Apps, 0, len(apps)+1)
+	found := false
+	for _, app := range apps {
+		if app.ID == devChannelID {
+			found = true
+			log.Printf("%q has %q sideloaded as its dev channel", deviceInfo.UserDeviceName, app.Name)
+			dev := *app
+			dev.Name = devChannelName
+			app = &dev
+		}
+		out = append(out, app)
+	}
+
+	if devMode && !found {
+		log.Printf("%q is in developer mode; adding an input for its dev channel", deviceInfo.UserDeviceName)
+		out = append(out, &roku.App{ID: devChannelID, Name: devChannelName, Type: "appl"})
+	}
+
+	return out
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/picatz/roku"
+)
+
+// allDeviceInfoFields selects every device info field for
+// -device-info-fields.
+const allDeviceInfoFields = "all"
+
+// deviceInfoFieldNames maps the names of device info fields, as the
+// roku package names them in JSON, to their indexes in
+// roku.DeviceInfo.
+var deviceInfoFieldNames = func() map[string]int {
+	names := map[string]int{}
+	t := reflect.TypeOf(roku.DeviceInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "text" || f.Type.Kind() != reflect.String {
+			continue
+		}
+		names[name] = i
+	}
+	return names
+}()
+
+// parseDeviceInfoFields parses a comma-separated list of device info
+// field names, like uptime,time_zone.
+func parseDeviceInfoFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch {
+		case f == "":
+			continue
+		case f == allDeviceInfoFields:
+			fields = fields[:0]
+			for name := range deviceInfoFieldNames {
+				fields = append(fields, name)
+			}
+			sort.Strings(fields)
+			return fields, nil
+		}
+
+		if _, ok := deviceInfoFieldNames[strings.Replace(f, "-", "_", -1)]; !ok {
+			return nil, fmt.Errorf("unknown device info field %q", f)
+		}
+		fields = append(fields, strings.Replace(f, "-", "_", -1))
+	}
+	return fields, nil
+}
+
+// deviceInfoValues returns the values of fields in info.  Fields the
+// Roku didn't report are left out.
+func deviceInfoValues(info *roku.DeviceInfo, fields []string) map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(info).Elem()
+	values := map[string]string{}
+	for _, f := range fields {
+		if s := v.Field(deviceInfoFieldNames[f]).String(); s != "" {
+			values[f] = s
+		}
+	}
+	return values
+}
+
+// macAddress returns the MAC address of the network interface the
+// Roku is using, for waking it with Wake-on-LAN.
+func macAddress(info *roku.DeviceInfo) string {
+	if info.NetworkType == "ethernet" {
+		return info.EthernetMac
+	}
+	return info.WifiMac
+}
+
+// currentDeviceInfo returns the most recently fetched device info, or
+// the device info from setup if none has been fetched since.
+func (r *Roku) currentDeviceInfo() *roku.DeviceInfo {
+	r.latestMu.Lock()
+	defer r.latestMu.Unlock()
+
+	if r.latest != nil {
+		return r.latest
+	}
+	return r.deviceInfo
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deviceConfig holds settings for an individual Roku.  The devices
+// config file is a JSON object mapping serial numbers to these.
+type deviceConfig struct {
+	// VisibleInputs lists the IDs of apps shown in the Home app's
+	// input picker.  Other apps are added as hidden inputs, which can
+	// still be selected from the accessory settings.  When empty, all
+	// inputs are shown.
+	VisibleInputs []string `json:"visible_inputs,omitempty"`
+
+	// PowerOnApp is the ID of an app to launch when the Roku is
+	// turned on from HomeKit.  It isn't launched if the Roku was
+	// already on.
+	PowerOnApp string `json:"power_on_app,omitempty"`
+
+	// Name overrides the name the Roku reports for itself.
+	Name string `json:"name,omitempty"`
+
+	// PIN overrides -homekit-pin for this Roku.
+	PIN string `json:"pin,omitempty"`
+
+	// Port is the port the HomeKit accessory listens on, overriding
+	// -port-base.
+	Port int `json:"port,omitempty"`
+
+	// ECPPort is the port the Roku answers ECP requests on, instead of
+	// the one it was found at, for reaching it through a port forward
+	// or proxy.
+	ECPPort int `json:"ecp_port,omitempty"`
+
+	// MDNSName is the accessory's mDNS instance name, which defaults
+	// to the Roku's name.  Setting it avoids collisions with other
+	// HomeKit accessories on the network.
+	MDNSName string `json:"mdns_name,omitempty"`
+
+	// UnreachableAfter and ReachableAfter override -unreachable-after
+	// and -reachable-after for this Roku.
+	UnreachableAfter int `json:"unreachable_after,omitempty"`
+	ReachableAfter   int `json:"reachable_after,omitempty"`
+
+	// UnreachableGrace overrides -unreachable-grace for this Roku.
+	// It's a duration string like "10m".
+	UnreachableGrace string `json:"unreachable_grace,omitempty"`
+	unreachableGrace time.Duration
+
+	// PollInterval overrides -poll-interval for this Roku.  It's a
+	// duration string like "5s".
+	PollInterval string `json:"poll_interval,omitempty"`
+	pollInterval time.Duration
+
+	// LaunchVerifyDelay overrides -launch-verify-delay for this Roku.
+	// It's a duration string like "3s".
+	LaunchVerifyDelay string `json:"launch_verify_delay,omitempty"`
+	launchVerifyDelay *time.Duration
+
+	// AutoOff turns the Roku off after leaving certain apps.
+	AutoOff *autoOffRule `json:"auto_off,omitempty"`
+
+	// Kiosk cycles the Roku through apps while its "Kiosk" switch is
+	// on.
+	Kiosk *kioskConfig `json:"kiosk,omitempty"`
+
+	// PowerCooldown overrides -power-cooldown for this Roku.  It's a
+	// duration string like "30s".
+	PowerCooldown string `json:"power_cooldown,omitempty"`
+	powerCooldown *time.Duration
+
+	// PowerReadOnly reports the Roku's power state to HomeKit but
+	// ignores requests to turn it on or off.
+	PowerReadOnly bool `json:"power_read_only,omitempty"`
+
+	// PowerOnKey and PowerOffKey are the keys sent to turn the Roku on
+	// and off, instead of PowerOn and PowerOff.  Some TVs behave
+	// better with the Power key, which toggles.
+	PowerOnKey  string `json:"power_on_key,omitempty"`
+	PowerOffKey string `json:"power_off_key,omitempty"`
+
+	// RemoteMacros maps keys on HomeKit's remote, by name, to
+	// sequences of Roku keys sent instead of the usual key.
+	RemoteMacros map[string][]string `json:"remote_macros,omitempty"`
+	remoteMacros map[int][]string
+
+	// RemoteWhenOff is what to do with keys from HomeKit's remote
+	// while the Roku is off: "send" them anyway (the default),
+	// "ignore" them, or "wake" the Roku and send them after
+	// RemoteWakeDelay, a duration string like "3s".
+	RemoteWhenOff   string `json:"remote_when_off,omitempty"`
+	RemoteWakeDelay string `json:"remote_wake_delay,omitempty"`
+	remoteWakeDelay time.Duration
+}
+
+const (
+	remoteWhenOffSend   = "send"
+	remoteWhenOffIgnore = "ignore"
+	remoteWhenOffWake   = "wake"
+
+	// defaultRemoteWakeDelay is how long to wait after waking the Roku
+	// before sending a key from HomeKit's remote.
+	defaultRemoteWakeDelay = 2 * time.Second
+)
+
+// limitDevices returns at most cfg.maxDevices of found, logging those
+// that are left out.  Devices listed in the devices config are
+// preferred, in discovery order, over ones that aren't.
+func (cfg *config) limitDevices(found []discovered) []discovered {
+	if cfg.maxDevices <= 0 || len(found) <= cfg.maxDevices {
+		return found
+	}
+
+	sorted := make([]discovered, len(found))
+	copy(sorted, found)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		_, iok := cfg.devices[sorted[i].deviceInfo.SerialNumber]
+		_, jok := cfg.devices[sorted[j].deviceInfo.SerialNumber]
+		return iok && !jok
+	})
+
+	for _, d := range sorted[cfg.maxDevices:] {
+		log.Printf("Not setting up %q (%s): limit of %d devices reached",
+			d.deviceInfo.UserDeviceName, d.deviceInfo.SerialNumber, cfg.maxDevices)
+	}
+
+	return sorted[:cfg.maxDevices]
+}
+
+// defaultDevice is the key in the devices config for settings that
+// apply to every Roku unless overridden by its own settings.
+const defaultDevice = "default"
+
+// loadDeviceConfigs reads the devices config, returning the settings
+// for each serial number with the defaults merged in, and the defaults
+// on their own for Rokus that aren't listed.
+func loadDeviceConfigs(path string) (map[string]*deviceConfig, *deviceConfig, error) {
+	devices := map[string]*deviceConfig{}
+	if path == "" {
+		return devices, nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read devices config: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse devices config %s: %w", path, err)
+	}
+
+	var defaults *deviceConfig
+	defaultRaw, ok := raw[defaultDevice]
+	if ok {
+		defaults = &deviceConfig{}
+		if err := json.Unmarshal(defaultRaw, defaults); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse devices config %s: %w", path, err)
+		}
+		// These have to be different for each Roku.
+		if defaults.Name != "" || defaults.Port != 0 || defaults.MDNSName != "" {
+			return nil, nil, fmt.Errorf("invalid default settings in %s: name, port and mdns_name can't be defaulted", path)
+		}
+		if err := defaults.validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid default settings in %s: %w", path, err)
+		}
+		delete(raw, defaultDevice)
+	}
+
+	for serial, r := range raw {
+		// Unmarshaling the device's settings over the defaults
+		// replaces the ones it sets and leaves the rest.
+		dc := &deviceConfig{}
+		if defaults != nil {
+			if err := json.Unmarshal(defaultRaw, dc); err != nil {
+				return nil, nil, fmt.Errorf("unable to parse devices config %s: %w", path, err)
+			}
+		}
+		if err := json.Unmarshal(r, dc); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse devices config %s: %w", path, err)
+		}
+
+		if err := dc.validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid settings for %s in %s: %w", serial, path, err)
+		}
+		devices[serial] = dc
+	}
+
+	return devices, defaults, nil
+}
+
+// readDeviceConfigs reads the devices config as written, without
+// merging the defaults into each Roku's settings.
+func readDeviceConfigs(path string) (map[string]*deviceConfig, error) {
+	devices := map[string]*deviceConfig{}
+	if path == "" {
+		return devices, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read devices config: %w", err)
+	}
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return nil, fmt.Errorf("unable to parse devices config %s: %w", path, err)
+	}
+	return devices, nil
+}
+
+// validate checks the settings, canonicalizes key names and parses
+// durations.
+func (dc *deviceConfig) validate() error {
+	for _, key := range []*string{&dc.PowerOnKey, &dc.PowerOffKey} {
+		if *key == "" {
+			continue
+		}
+
+		k, err := canonicalKey(*key)
+		if err != nil {
+			return err
+		}
+		*key = k
+	}
+
+	if dc.UnreachableGrace != "" {
+		d, err := time.ParseDuration(dc.UnreachableGrace)
+		if err != nil {
+			return fmt.Errorf("invalid unreachable_grace: %w", err)
+		}
+		dc.unreachableGrace = d
+	}
+
+	macros, err := parseRemoteMacros(dc.RemoteMacros)
+	if err != nil {
+		return err
+	}
+	dc.remoteMacros = macros
+
+	if dc.PollInterval != "" {
+		d, err := time.ParseDuration(dc.PollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid poll_interval: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("poll_interval must be positive")
+		}
+		dc.pollInterval = d
+	}
+
+	if dc.AutoOff != nil {
+		if err := dc.AutoOff.validate(); err != nil {
+			return fmt.Errorf("invalid auto_off: %w", err)
+		}
+		if dc.PowerReadOnly {
+			return fmt.Errorf("auto_off can't be used with power_read_only")
+		}
+	}
+
+	if dc.Kiosk != nil {
+		if err := dc.Kiosk.validate(); err != nil {
+			return fmt.Errorf("invalid kiosk: %w", err)
+		}
+	}
+
+	if dc.LaunchVerifyDelay != "" {
+		d, err := time.ParseDuration(dc.LaunchVerifyDelay)
+		if err != nil {
+			return fmt.Errorf("invalid launch_verify_delay: %w", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("launch_verify_delay must not be negative")
+		}
+		dc.launchVerifyDelay = &d
+	}
+
+	if dc.ECPPort != 0 {
+		if _, err := parseECPPort(strconv.Itoa(dc.ECPPort)); err != nil {
+			return fmt.Errorf("invalid ecp_port: %w", err)
+		}
+	}
+
+	if dc.PowerCooldown != "" {
+		d, err := time.ParseDuration(dc.PowerCooldown)
+		if err != nil {
+			return fmt.Errorf("invalid power_cooldown: %w", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("power_cooldown must not be negative")
+		}
+		dc.powerCooldown = &d
+	}
+
+	switch dc.RemoteWhenOff {
+	case "", remoteWhenOffSend, remoteWhenOffIgnore, remoteWhenOffWake:
+	default:
+		return fmt.Errorf("invalid remote_when_off %q; must be send, ignore or wake", dc.RemoteWhenOff)
+	}
+	if dc.RemoteWhenOff == remoteWhenOffWake && dc.PowerReadOnly {
+		return fmt.Errorf("remote_when_off wake can't be used with power_read_only")
+	}
+
+	dc.remoteWakeDelay = defaultRemoteWakeDelay
+	if dc.RemoteWakeDelay != "" {
+		d, err := time.ParseDuration(dc.RemoteWakeDelay)
+		if err != nil {
+			return fmt.Errorf("invalid remote_wake_delay: %w", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("remote_wake_delay must not be negative")
+		}
+		dc.remoteWakeDelay = d
+	}
+
+	return nil
+}
+
+// device returns the config for the Roku with the given serial number,
+// with any overrides from the environment applied.  It never returns
+// nil.
+func (cfg *config) device(serial string) *deviceConfig {
+	dc := &deviceConfig{}
+	if c := cfg.devices[serial]; c != nil {
+		*dc = *c
+	} else if cfg.defaultDevice != nil {
+		*dc = *cfg.defaultDevice
+	}
+	dc.applyEnv(serial)
+	return dc
+}
+
+// applyEnv overrides settings from environment variables named
+// ROKU_DEVICE_<SERIAL>_<SETTING>, which are handier than a config file
+// in containers.  Any characters in the serial number that can't be
+// used in a variable name are replaced with underscores.
+func (dc *deviceConfig) applyEnv(serial string) {
+	prefix := "ROKU_DEVICE_" + envName(serial) + "_"
+
+	if v, ok := os.LookupEnv(prefix + "NAME"); ok {
+		dc.Name = v
+	}
+	if v, ok := os.LookupEnv(prefix + "PIN"); ok {
+		dc.PIN = v
+	}
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func (dc *deviceConfig) inputVisible(appID string) bool {
+	if len(dc.VisibleInputs) == 0 {
+		return true
+	}
+
+	for _, id := range dc.VisibleInputs {
+		if id == appID {
+			return true
+		}
+	}
+
+	return false
+}
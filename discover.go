@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	ssdp "github.com/koron/go-ssdp"
+	"github.com/picatz/roku"
+)
+
+// discovered is a Roku found on the network that hasn't been set up.
+type discovered struct {
+	endpoint   controller
+	deviceInfo *roku.DeviceInfo
+}
+
+// discover searches the local network for Rokus and fetches their
+// device info.  Rokus that don't answer with device info are skipped.
+func discover(cfg *config) ([]discovered, error) {
+	found, _, err := search(cfg)
+	return found, err
+}
+
+// search is like discover, but also returns the endpoints that
+// answered the search but not the device info query.  They might be
+// Rokus that are still booting, which can be tried again later.
+func search(cfg *config) ([]discovered, []*ecpEndpoint, error) {
+	var ecpEndpoints []*ecpEndpoint
+	seen := map[string]bool{}
+	for _, addr := range cfg.rokuAddresses {
+		e := cfg.newEndpoint(roku.NewEndpoint(fmt.Sprintf("http://%s/", addr)))
+		ecpEndpoints = append(ecpEndpoints, e)
+		seen[e.host()] = true
+	}
+
+	if cfg.noSSDP {
+		if len(cfg.rokuAddresses) == 0 && len(cfg.scanCIDRs) == 0 {
+			log.Println("Not searching for Rokus with SSDP, and no -roku-address or -scan-cidr given")
+		}
+	} else {
+		log.Println("Searching for Rokus...")
+
+		endpoints, err := roku.Find(5)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, re := range endpoints {
+			e := cfg.newEndpoint(re)
+			if seen[e.host()] {
+				continue
+			}
+			ecpEndpoints = append(ecpEndpoints, e)
+			seen[e.host()] = true
+		}
+	}
+
+	if len(cfg.scanCIDRs) > 0 {
+		ecpEndpoints = append(ecpEndpoints, scan(cfg, seen)...)
+	}
+
+	var (
+		found   []discovered
+		pending []*ecpEndpoint
+	)
+	for _, e := range ecpEndpoints {
+		deviceInfo, err := cfg.fetchDeviceInfo(e, cfg.deviceInfoAttempts)
+		if err != nil {
+			log.Printf("unable to get device info for %s: %v", e, err)
+			pending = append(pending, e)
+			continue
+		}
+
+		ep := cfg.withECPPort(e, cfg.device(deviceInfo.SerialNumber).ECPPort)
+		found = append(found, discovered{endpoint: ep, deviceInfo: deviceInfo})
+	}
+
+	return dedupe(found), pending, nil
+}
+
+// dedupe removes Rokus found more than once, which happens on hosts
+// with several interfaces on the same network, where a Roku can answer
+// at different addresses.  Of each Roku's addresses, the one that
+// answers a probe fastest is kept.
+func dedupe(found []discovered) []discovered {
+	bySerial := map[string][]discovered{}
+	var serials []string
+	for _, d := range found {
+		s := d.deviceInfo.SerialNumber
+		if _, ok := bySerial[s]; !ok {
+			serials = append(serials, s)
+		}
+		bySerial[s] = append(bySerial[s], d)
+	}
+
+	deduped := make([]discovered, 0, len(serials))
+	for _, s := range serials {
+		ds := bySerial[s]
+		if len(ds) == 1 || s == "" {
+			deduped = append(deduped, ds...)
+			continue
+		}
+
+		best, bestLatency := -1, time.Duration(0)
+		for i, d := range ds {
+			start := time.Now()
+			if _, err := d.endpoint.DeviceInfo(); err != nil {
+				log.Printf("%q at %s didn't answer a probe: %v", d.deviceInfo.UserDeviceName, d.endpoint, err)
+				continue
+			}
+			if latency := time.Since(start); best < 0 || latency < bestLatency {
+				best, bestLatency = i, latency
+			}
+		}
+		if best < 0 {
+			// None answered; any of them is as good as another.
+			best = 0
+		}
+
+		d := ds[best]
+		log.Printf("%q was found at %d addresses; using %s", d.deviceInfo.UserDeviceName, len(ds), d.endpoint)
+		deduped = append(deduped, d)
+	}
+
+	return deduped
+}
+
+func (cfg *config) newEndpoint(e *roku.Endpoint) *ecpEndpoint {
+	return &ecpEndpoint{Endpoint: e, timeouts: cfg.timeouts, inflight: cfg.inflight}
+}
+
+// parseRokuAddresses parses a comma-separated list of Roku hostnames
+// or IP addresses, each optionally with an ECP port like
+// 192.168.1.20:18060 or [fd00::20]:18060, returning them with the port.
+// The port defaults to the usual 8060.  IPv6 addresses may be given
+// with or without brackets.
+func parseRokuAddresses(s string) ([]string, error) {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+
+		host, port := a, ecpPort
+		if ip := strings.TrimSuffix(strings.TrimPrefix(a, "["), "]"); len(ip) == len(a)-2 && net.ParseIP(ip) != nil {
+			host = ip
+		} else if net.ParseIP(a) == nil {
+			if h, p, err := net.SplitHostPort(a); err == nil {
+				n, err := parseECPPort(p)
+				if err != nil {
+					return nil, fmt.Errorf("invalid Roku address %q: %w", a, err)
+				}
+				host, port = h, n
+			}
+		}
+
+		if host == "" || (strings.ContainsAny(host, "/:[]") && net.ParseIP(host) == nil) {
+			return nil, fmt.Errorf("invalid Roku address %q: must be a hostname or IP address, optionally with a port", a)
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return addrs, nil
+}
+
+// parseECPPort parses an ECP port number.
+func parseECPPort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("port %d out of range 1-65535", n)
+	}
+	return n, nil
+}
+
+// withECPPort returns an endpoint for e's host on the given port, or e
+// itself if port is 0, e is already on that port or e isn't a real Roku.
+func (cfg *config) withECPPort(e controller, port int) controller {
+	if _, ok := e.(*ecpEndpoint); !ok || port == 0 || endpointPort(e) == port {
+		return e
+	}
+	addr := net.JoinHostPort(e.host(), strconv.Itoa(port))
+	return cfg.newEndpoint(roku.NewEndpoint(fmt.Sprintf("http://%s/", addr)))
+}
+
+// haveMulticast reports whether any network interface that SSDP would
+// search on is up and supports multicast.
+func haveMulticast() bool {
+	ifaces := ssdp.Interfaces
+	if len(ifaces) == 0 {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return true
+		}
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stabilizeIdentity replaces the serial number of a Roku given an ID
+// with -stable-ids, and fills in the serial number of one that doesn't
+// report one from its other identifiers.  The serial number is used
+// for the device's storage directory and settings, so without a
+// stable one the accessory would need to be paired again after every
+// restart.
+func (cfg *config) stabilizeIdentity(deviceInfo *roku.DeviceInfo) {
+	ids := []string{
+		deviceInfo.DeviceID,
+		deviceInfo.EthernetMac,
+		deviceInfo.WifiMac,
+		deviceInfo.Udn,
+	}
+
+	for _, id := range append([]string{deviceInfo.SerialNumber}, ids...) {
+		if stable, ok := cfg.stableIDs[normalizeIdentifier(id)]; id != "" && ok {
+			if stable != deviceInfo.SerialNumber {
+				log.Printf("Using %s from -stable-ids for %q, whose serial number is %q", stable, deviceInfo.UserDeviceName, deviceInfo.SerialNumber)
+				deviceInfo.SerialNumber = stable
+			}
+			return
+		}
+	}
+
+	if deviceInfo.SerialNumber != "" {
+		return
+	}
+
+	for _, id := range ids {
+		id = normalizeIdentifier(id)
+		// Rokus without Ethernet report an all-zero MAC for it.
+		if strings.Trim(id, "0") != "" {
+			log.Printf("%q has no serial number; using %s instead", deviceInfo.UserDeviceName, id)
+			deviceInfo.SerialNumber = id
+			return
+		}
+	}
+
+	log.Printf("%q has no serial number or other identifier; it may need to be paired again after restarts", deviceInfo.UserDeviceName)
+}
+
+// normalizeIdentifier uppercases a serial number, device ID, MAC
+// address or UDN and strips its separators.
+func normalizeIdentifier(id string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(id))
+}
+
+// parseStableIDs parses -stable-ids, comma-separated identifier=id
+// pairs, into a map keyed by the normalized identifier.  The IDs name
+// storage directories, so they can't contain path separators.
+func parseStableIDs(s string) (map[string]string, error) {
+	ids := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't identifier=id", pair)
+		}
+		key, id := normalizeIdentifier(strings.TrimSpace(pair[:i])), strings.TrimSpace(pair[i+1:])
+		if key == "" || id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+			return nil, fmt.Errorf("%q isn't identifier=id", pair)
+		}
+		ids[key] = id
+	}
+	return ids, nil
+}
+
+// setDiscoverInterfaces limits SSDP discovery to the named,
+// comma-separated network interfaces.  By default, every interface
+// that's up and supports multicast is used.
+func setDiscoverInterfaces(names string) error {
+	if names == "" {
+		return nil
+	}
+
+	var ifaces []net.Interface
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("invalid discovery interface %q: %w", name, err)
+		}
+		if iface.Flags&net.FlagMulticast == 0 {
+			return fmt.Errorf("discovery interface %q doesn't support multicast", name)
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			log.Printf("Discovery interface %q is down", name)
+		}
+
+		ifaces = append(ifaces, *iface)
+	}
+
+	// roku.Find doesn't take any options, but the ssdp package it
+	// uses searches on these interfaces when they're set.
+	ssdp.Interfaces = ifaces
+	return nil
+}
+
+// selectDevice returns the Roku in found with the given serial number.
+// If serial is empty and only one Roku was found, that one is returned.
+func selectDevice(found []discovered, serial string) (*discovered, error) {
+	if serial == "" && len(found) == 1 {
+		return &found[0], nil
+	}
+
+	for i := range found {
+		if found[i].deviceInfo.SerialNumber == serial {
+			return &found[i], nil
+		}
+	}
+
+	var b strings.Builder
+	if serial == "" {
+		fmt.Fprintf(&b, "found %d Rokus; specify a serial number:", len(found))
+	} else {
+		fmt.Fprintf(&b, "no Roku with serial number %q found; found:", serial)
+	}
+	for _, d := range found {
+		fmt.Fprintf(&b, "\n  %s\t%s", d.deviceInfo.SerialNumber, d.deviceInfo.UserDeviceName)
+	}
+
+	return nil, fmt.Errorf("%s", b.String())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/picatz/roku"
+)
+
+func TestParseRokuAddresses(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"192.168.1.20", []string{"192.168.1.20:8060"}},
+		{"192.168.1.20:18060", []string{"192.168.1.20:18060"}},
+		{"roku.local", []string{"roku.local:8060"}},
+		{"roku.local:18060", []string{"roku.local:18060"}},
+		{"fd00::20", []string{"[fd00::20]:8060"}},
+		{"[fd00::20]", []string{"[fd00::20]:8060"}},
+		{"[fd00::20]:18060", []string{"[fd00::20]:18060"}},
+		{" 192.168.1.20 , , roku.local", []string{"192.168.1.20:8060", "roku.local:8060"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRokuAddresses(tt.in)
+		if err != nil {
+			t.Errorf("parseRokuAddresses(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRokuAddresses(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"192.168.1.20:0",
+		"192.168.1.20:65536",
+		"192.168.1.20:http",
+		"[fd00::20",
+		"[roku.local]",
+		"http://192.168.1.20/",
+		":8060",
+	} {
+		if got, err := parseRokuAddresses(in); err == nil {
+			t.Errorf("parseRokuAddresses(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestWithECPPort(t *testing.T) {
+	var cfg config
+	e := cfg.newEndpoint(roku.NewEndpoint("http://[fd00::20]:8060/"))
+
+	if got := cfg.withECPPort(e, 0); got != e {
+		t.Errorf("withECPPort(%s, 0) = %s, want it unchanged", e, got)
+	}
+	if got := cfg.withECPPort(e, 8060); got != e {
+		t.Errorf("withECPPort(%s, 8060) = %s, want it unchanged", e, got)
+	}
+	if got, want := cfg.withECPPort(e, 18060).String(), "http://[fd00::20]:18060/"; got != want {
+		t.Errorf("withECPPort(%s, 18060) = %s, want %s", e, got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/picatz/roku"
+)
+
+// accessoryModel and accessoryFirmware are how a Roku's model and
+// firmware appear in its accessory information.
+func accessoryModel(info *roku.DeviceInfo) string {
+	return fmt.Sprintf("%s (%s)", info.FriendlyModelName, info.ModelNumber)
+}
+
+func accessoryFirmware(info *roku.DeviceInfo) string {
+	return fmt.Sprintf("%s-%s", info.SoftwareVersion, info.SoftwareBuild)
+}
+
+// infoChanged reports whether any of the device info that shows in the
+// accessory's information differs between a and b.
+func infoChanged(a, b *roku.DeviceInfo) bool {
+	return a.FriendlyModelName != b.FriendlyModelName ||
+		a.ModelNumber != b.ModelNumber ||
+		a.SoftwareVersion != b.SoftwareVersion ||
+		a.SoftwareBuild != b.SoftwareBuild ||
+		a.UserDeviceName != b.UserDeviceName
+}
+
+// updateInfo brings the accessory's model, firmware revision and name
+// up to date with what the Roku reported in a poll, after a firmware
+// update or the Roku being renamed.  None of these are part of the
+// pairing, so they can change freely.  The name is left alone if it's
+// set in the devices config, or if it was changed in the Home app and
+// configured-name isn't managed.
+func (r *Roku) updateInfo(info *roku.DeviceInfo) {
+	name := r.deviceInfo.UserDeviceName
+
+	if model := accessoryModel(info); r.accessory.Info.Model.GetValue() != model {
+		log.Printf("Model of %q changed from %q to %q", name, r.accessory.Info.Model.GetValue(), model)
+		r.accessory.Info.Model.SetValue(model)
+	}
+
+	if fw := accessoryFirmware(info); r.accessory.Info.FirmwareRevision.GetValue() != fw {
+		log.Printf("Firmware of %q changed from %s to %s", name, r.accessory.Info.FirmwareRevision.GetValue(), fw)
+		r.accessory.Info.FirmwareRevision.SetValue(fw)
+	}
+
+	if r.cfg.Name != "" || (r.savedTVName() != "" && !r.manages(managedConfiguredName)) {
+		return
+	}
+
+	newName := strings.Replace(deviceName(r.global, info), `"`, "", -1)
+	if old := r.tv.ConfiguredName.GetValue(); old != newName {
+		log.Printf("Name of %q changed from %q to %q", name, old, newName)
+		r.tv.ConfiguredName.SetValue(newName)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// secretFlags are left out of dump-config's output.  Webhook URLs
+// often carry a token in their path or query.
+var secretFlags = map[string]bool{
+	"homekit-pin": true,
+	"api-token":   true,
+	"webhook-url": true,
+}
+
+const redacted = "(redacted)"
+
+type configDump struct {
+	Flags   map[string]string        `json:"flags"`
+	Devices map[string]*deviceConfig `json:"devices"`
+}
+
+// runDumpConfig prints the configuration in effect after merging the
+// command line, environment and config file, including the per-device
+// settings with their environment overrides.
+func runDumpConfig(cfg *config, fs *flag.FlagSet, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: roku-homekit dump-config")
+		return 2
+	}
+
+	dump := configDump{
+		Flags:   map[string]string{},
+		Devices: map[string]*deviceConfig{},
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if secretFlags[f.Name] && v != "" {
+			v = redacted
+		}
+		dump.Flags[f.Name] = v
+	})
+
+	for serial := range cfg.devices {
+		dc := cfg.device(serial)
+		if dc.PIN != "" {
+			dc.PIN = redacted
+		}
+		dump.Devices[serial] = dc
+	}
+	if d := cfg.defaultDevice; d != nil {
+		dc := *d
+		if dc.PIN != "" {
+			dc.PIN = redacted
+		}
+		dump.Devices[defaultDevice] = &dc
+	}
+
+	b, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(b))
+	return 0
+}
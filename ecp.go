@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/picatz/roku"
+)
+
+// ecpTimeouts bounds how long we wait on different kinds of ECP
+// requests.  Launching an app can take several seconds on slower
+// devices, while queries and keypresses should be quick.
+type ecpTimeouts struct {
+	query    time.Duration
+	keypress time.Duration
+	launch   time.Duration
+}
+
+func (t ecpTimeouts) max() time.Duration {
+	m := t.query
+	if t.keypress > m {
+		m = t.keypress
+	}
+	if t.launch > m {
+		m = t.launch
+	}
+	return m
+}
+
+type timeoutError struct {
+	op string
+	d  time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v", e.op, e.d)
+}
+
+// callWithTimeout runs fn, giving up after d.  The roku package doesn't
+// take a context, so fn carries on in the background after a timeout;
+// the HTTP client timeout set in main keeps it from running forever.
+func callWithTimeout(op string, d time.Duration, fn func() error) error {
+	if d <= 0 {
+		return fn()
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(d):
+		return &timeoutError{op: op, d: d}
+	}
+}
+
+// isPersistent reports whether err from an ECP query is likely to
+// happen again no matter how often the request is retried.  Rokus with
+// ECP restricted in their settings answer some queries with an error
+// page rather than XML, which is reported as an unexpected response, a
+// decoding error, or no apps being found.  Server errors from a Roku
+// that's busy might go away, as might an EOF from a connection that
+// was closed before the Roku answered.
+func isPersistent(err error) bool {
+	var (
+		syntaxErr   *xml.SyntaxError
+		responseErr *unexpectedResponseError
+	)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return false
+	case errors.Is(err, roku.ErrNoAppsFound),
+		errors.As(err, &syntaxErr):
+		return true
+	case errors.As(err, &responseErr):
+		return responseErr.status < 500
+	}
+	return false
+}
+
+// fetchApps gets the list of apps from e, trying up to attempts times
+// unless the failure looks persistent.
+func fetchApps(e controller, attempts int) (roku.Apps, error) {
+	const delay = 2 * time.Second
+
+	for attempt := 1; ; attempt++ {
+		apps, err := e.Apps()
+		if err == nil {
+			return apps, nil
+		}
+
+		if isPersistent(err) {
+			log.Printf("Apps unavailable from %s, not retrying: %v", e, err)
+			return nil, err
+		}
+
+		if attempt >= attempts {
+			return nil, err
+		}
+
+		log.Printf("Error getting apps from %s (attempt %d of %d), retrying in %v: %v", e, attempt, attempts, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// fetchDeviceInfo gets the device info of a newly found Roku from e,
+// trying up to attempts times, and stabilizes its identity.  Rokus
+// that are still booting answer the discovery search a little before
+// they answer queries, so the delay between attempts doubles each
+// time, starting from a second, with some jitter.
+func (cfg *config) fetchDeviceInfo(e controller, attempts int) (*roku.DeviceInfo, error) {
+	delay := time.Second
+
+	for attempt := 1; ; attempt++ {
+		deviceInfo, err := e.DeviceInfo()
+		if err == nil {
+			cfg.stabilizeIdentity(deviceInfo)
+			return deviceInfo, nil
+		}
+
+		if attempt >= attempts || isPersistent(err) {
+			return nil, err
+		}
+
+		d := delay + time.Duration(rand.Int63n(int64(delay/2)))
+		log.Printf("Error getting device info from %s (attempt %d of %d), retrying in %v: %v", e, attempt, attempts, d.Round(time.Millisecond), err)
+		time.Sleep(d)
+		delay *= 2
+	}
+}
+
+// controller is the part of ECP a Roku accessory uses.  It's an
+// interface so that tests can stand in for a Roku.
+type controller interface {
+	DeviceInfo() (*roku.DeviceInfo, error)
+	Apps() (roku.Apps, error)
+	ActiveApp() (*roku.App, error)
+	Icon(id string) ([]byte, error)
+	Keypress(key string) error
+	FindRemote() error
+	LaunchApp(id string, params map[string]string) error
+	Input(params map[string]string, appID string) error
+	InstallApp(id string) error
+	String() string
+	host() string
+}
+
+// ecpEndpoint wraps a roku.Endpoint, applying per-operation timeouts.
+type ecpEndpoint struct {
+	*roku.Endpoint
+	timeouts ecpTimeouts
+
+	// inflight, if not nil, is a semaphore shared by all endpoints
+	// that bounds how many ECP requests are made at once.
+	inflight chan struct{}
+}
+
+// call runs fn with a timeout of d, once there's room for another
+// request in flight.  Time spent waiting for room doesn't count
+// toward the timeout.  The slot is held until fn returns, even after a
+// timeout, since the request is still open until then.
+func (e *ecpEndpoint) call(op string, d time.Duration, fn func() error) error {
+	if e.inflight == nil {
+		return callWithTimeout(op, d, fn)
+	}
+
+	e.inflight <- struct{}{}
+	return callWithTimeout(op, d, func() error {
+		defer func() { <-e.inflight }()
+		return fn()
+	})
+}
+
+func (e *ecpEndpoint) DeviceInfo() (*roku.DeviceInfo, error) {
+	var deviceInfo *roku.DeviceInfo
+	err := e.call("device info query", e.timeouts.query, func() (err error) {
+		deviceInfo, err = e.Endpoint.DeviceInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deviceInfo, nil
+}
+
+func (e *ecpEndpoint) Apps() (roku.Apps, error) {
+	var apps roku.Apps
+	err := e.call("apps query", e.timeouts.query, func() (err error) {
+		apps, err = e.Endpoint.Apps()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+func (e *ecpEndpoint) ActiveApp() (*roku.App, error) {
+	var app *roku.App
+	err := e.call("active app query", e.timeouts.query, func() (err error) {
+		app, err = e.queryActiveApp()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// queryActiveApp is like roku.Endpoint's ActiveApp, but it also
+// decodes the screensaver, which the roku package ignores.
+func (e *ecpEndpoint) queryActiveApp() (*roku.App, error) {
+	resp, err := http.Get(strings.TrimSuffix(e.String(), "/") + "/query/active-app")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseActiveApp(resp.Body)
+}
+
+// activeAppResponse is the body of an active app query.  While the
+// screensaver is running, it's reported alongside the app underneath.
+type activeAppResponse struct {
+	App         *roku.App `xml:"app"`
+	Screensaver *roku.App `xml:"screensaver"`
+}
+
+// parseActiveApp decodes an active app query's response, returning the
+// screensaver if it's running and the app otherwise.
+func parseActiveApp(r io.Reader) (*roku.App, error) {
+	var resp activeAppResponse
+	if err := xml.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Screensaver != nil {
+		app := *resp.Screensaver
+		if app.Type == "" {
+			app.Type = screensaverAppType
+		}
+		return &app, nil
+	}
+	if resp.App == nil {
+		return nil, roku.ErrNoAppFound
+	}
+	return resp.App, nil
+}
+
+func (e *ecpEndpoint) Icon(id string) ([]byte, error) {
+	var b []byte
+	err := e.call("icon query", e.timeouts.query, func() (err error) {
+		b, err = e.Endpoint.Icon(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (e *ecpEndpoint) Keypress(key string) error {
+	return e.call("keypress", e.timeouts.keypress, func() error {
+		return e.Endpoint.Keypress(key)
+	})
+}
+
+func (e *ecpEndpoint) FindRemote() error {
+	return e.Keypress(roku.FindRemoteKey)
+}
+
+func (e *ecpEndpoint) LaunchApp(id string, params map[string]string) error {
+	return e.call("launch", e.timeouts.launch, func() error {
+		return e.Endpoint.LaunchApp(id, params)
+	})
+}
+
+// Input sends an ECP input event, optionally to the app with the given
+// ID.
+func (e *ecpEndpoint) Input(params map[string]string, appID string) error {
+	var opts *roku.InputOptions
+	if appID != "" {
+		opts = &roku.InputOptions{AppID: appID}
+	}
+	return e.call("input", e.timeouts.keypress, func() error {
+		return e.Endpoint.Input(params, opts)
+	})
+}
+
+// InstallApp shows the channel store page for the app with the given
+// ID, from which it can be installed.
+func (e *ecpEndpoint) InstallApp(id string) error {
+	return e.call("install", e.timeouts.launch, func() error {
+		return e.Endpoint.InstallApp(id, nil)
+	})
+}
+
+// host returns the Roku's address, without the scheme or port.
+func (e *ecpEndpoint) host() string {
+	u, err := url.Parse(e.String())
+	if err != nil {
+		return e.String()
+	}
+	return u.Hostname()
+}
+
+// endpointPort returns the port c's ECP requests go to, or 0 if it
+// doesn't use one, like a simulated Roku.
+func endpointPort(c controller) int {
+	u, err := url.Parse(c.String())
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
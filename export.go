@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/picatz/roku"
+)
+
+// configExport is everything needed to move roku-homekit to another
+// host: the per-device settings, and what's been changed from the Home
+// app or the API, like input names and visibility.  HomeKit pairing
+// data and PINs are only included on request.
+type configExport struct {
+	Devices map[string]*deviceConfig `json:"devices,omitempty"`
+
+	// Storage is keyed by each device's storage directory, relative to
+	// -storage-path, or absolute if it's outside of it.
+	Storage map[string]*storageExport `json:"storage,omitempty"`
+}
+
+type storageExport struct {
+	State   *deviceState      `json:"state,omitempty"`
+	Pairing map[string][]byte `json:"pairing,omitempty"`
+}
+
+// isDeviceStorage reports whether dir holds a device's state or HomeKit
+// pairing data, which always includes a uuid file.
+func isDeviceStorage(dir string) bool {
+	for _, name := range []string{stateFile, "uuid"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func runExportConfig(cfg *config, args []string) int {
+	fs := flag.NewFlagSet("export-config", flag.ContinueOnError)
+	pairing := fs.Bool("pairing", false, "Include HomeKit pairing data and PINs")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: roku-homekit export-config [-pairing]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return 2
+	}
+
+	exp, err := exportConfig(cfg, *pairing)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	b, err := json.MarshalIndent(exp, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(b))
+	return 0
+}
+
+func exportConfig(cfg *config, pairing bool) (*configExport, error) {
+	exp := &configExport{
+		Devices: map[string]*deviceConfig{},
+		Storage: map[string]*storageExport{},
+	}
+
+	// Settings are exported as written, so that the defaults still
+	// apply to each Roku after importing rather than being copied
+	// into its settings.  Environment overrides are particular to
+	// this host, so they're left out.
+	devices, err := readDeviceConfigs(cfg.devicesConfig)
+	if err != nil {
+		return nil, err
+	}
+	for serial, dc := range devices {
+		if !pairing {
+			dc.PIN = ""
+		}
+		exp.Devices[serial] = dc
+	}
+
+	dirs := map[string]bool{}
+	err = filepath.Walk(cfg.storagePath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == cfg.storagePath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if isStorageBackup(path) {
+			return filepath.SkipDir
+		}
+		if !isDeviceStorage(path) {
+			return nil
+		}
+
+		dirs[path] = true
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The storage layout can put a device's directory anywhere, so
+	// also look where it puts each configured device's.
+	for serial := range cfg.devices {
+		path, err := deviceStoragePath(cfg, &roku.DeviceInfo{SerialNumber: serial})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping storage for %s: %v\n", serial, err)
+			continue
+		}
+		if isDeviceStorage(path) {
+			dirs[filepath.Clean(path)] = true
+		}
+	}
+
+	for dir := range dirs {
+		se, err := exportStorage(dir, pairing)
+		if err != nil {
+			return nil, fmt.Errorf("unable to export %s: %w", dir, err)
+		}
+		if se.State != nil || len(se.Pairing) > 0 {
+			exp.Storage[storageKey(cfg, dir)] = se
+		}
+	}
+
+	return exp, nil
+}
+
+// isLayoutStorage reports whether dir is where the storage layout puts
+// the storage directory of one of devices.
+func isLayoutStorage(cfg *config, devices map[string]*deviceConfig, dir string) bool {
+	for serial := range devices {
+		if serial == defaultDevice {
+			continue
+		}
+		path, err := deviceStoragePath(cfg, &roku.DeviceInfo{SerialNumber: serial})
+		if err == nil && filepath.Clean(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// storageKey returns dir relative to -storage-path, or dir itself if
+// it's outside of it.
+func storageKey(cfg *config, dir string) string {
+	rel, err := filepath.Rel(cfg.storagePath, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(dir)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func exportStorage(dir string, pairing bool) (*storageExport, error) {
+	se := &storageExport{}
+
+	if _, err := os.Stat(filepath.Join(dir, stateFile)); err == nil {
+		st, err := loadDeviceState(dir)
+		if err != nil {
+			return nil, err
+		}
+		se.State = st
+	}
+
+	if !pairing {
+		return se, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == stateFile || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if se.Pairing == nil {
+			se.Pairing = map[string][]byte{}
+		}
+		se.Pairing[e.Name()] = b
+	}
+	return se, nil
+}
+
+func runImportConfig(cfg *config, args []string) int {
+	fs := flag.NewFlagSet("import-config", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Overwrite an existing devices config, state and pairing data")
+	devicesConfig := fs.String("devices-config", "", "Where to write the per-device settings")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: roku-homekit import-config [-force] [-devices-config file] <export>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	b, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var exp configExport
+	if err := json.Unmarshal(b, &exp); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to parse %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	if err := importConfig(cfg, &exp, *devicesConfig, *force); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// importConfig writes out an export.  Nothing is written if any of it
+// would overwrite existing files, unless force is set.
+func importConfig(cfg *config, exp *configExport, devicesConfig string, force bool) error {
+	type file struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}
+	var files []file
+
+	if len(exp.Devices) > 0 {
+		if devicesConfig == "" {
+			return errors.New("the export has per-device settings; give -devices-config to say where to write them")
+		}
+		for serial, dc := range exp.Devices {
+			if err := dc.validate(); err != nil {
+				return fmt.Errorf("invalid settings for %s: %w", serial, err)
+			}
+		}
+		b, err := json.MarshalIndent(exp.Devices, "", "  ")
+		if err != nil {
+			return err
+		}
+		files = append(files, file{devicesConfig, append(b, '\n'), 0644})
+	}
+
+	for rel, se := range exp.Storage {
+		rel = filepath.Clean(filepath.FromSlash(rel))
+		dir := rel
+		if filepath.IsAbs(rel) {
+			if !isLayoutStorage(cfg, exp.Devices, rel) {
+				return fmt.Errorf("invalid storage directory %q: it's outside -storage-path and not where -storage-layout puts a Roku in the export", rel)
+			}
+		} else {
+			if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("invalid storage directory %q", rel)
+			}
+			dir = filepath.Join(cfg.storagePath, rel)
+		}
+
+		if se.State != nil {
+			b, err := json.MarshalIndent(se.State, "", "  ")
+			if err != nil {
+				return err
+			}
+			files = append(files, file{filepath.Join(dir, stateFile), b, 0644})
+		}
+		for name, b := range se.Pairing {
+			if name != filepath.Base(name) || name == "." || name == ".." {
+				return fmt.Errorf("invalid pairing file name %q in %s", name, rel)
+			}
+			files = append(files, file{filepath.Join(dir, name), b, 0600})
+		}
+	}
+
+	if !force {
+		for _, f := range files {
+			if _, err := os.Stat(f.path); err == nil {
+				return fmt.Errorf("%s already exists; use -force to overwrite it", f.path)
+			}
+		}
+	}
+
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(f.path, f.data, f.perm); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", f.path)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/picatz/roku"
+)
+
+// Features that only work on some Rokus, and can be held back on
+// firmware older than a minimum.
+const (
+	featureVolume     = "volume"
+	featureMediaState = "media-state"
+	featureTVInput    = "tvinput"
+)
+
+var featureNames = []string{featureVolume, featureMediaState, featureTVInput}
+
+// parseFeatureFirmware parses a comma-separated list of minimum
+// firmware versions for features, like volume=9.4,tvinput=10.0.
+func parseFeatureFirmware(s string) (map[string]string, error) {
+	min := map[string]string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid feature firmware %q: must be feature=version", f)
+		}
+		name, version := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+
+		known := false
+		for _, n := range featureNames {
+			if n == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown feature %q; must be one of %s", name, strings.Join(featureNames, ", "))
+		}
+		if _, err := parseVersion(version); err != nil {
+			return nil, fmt.Errorf("invalid version for %s: %w", name, err)
+		}
+		min[name] = version
+	}
+	return min, nil
+}
+
+// parseVersion parses a dotted firmware version like 11.0.0.
+func parseVersion(s string) ([]int, error) {
+	var v []int
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+// versionAtLeast reports whether version v is min or newer.  Missing
+// parts count as 0, so 11 is the same as 11.0.0.
+func versionAtLeast(v, min []int) bool {
+	for i := 0; i < len(v) || i < len(min); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(min) {
+			b = min[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
+
+// detectFeatures decides which features to enable for a Roku: those
+// its device info says it supports, on firmware at least as new as
+// any -feature-min-firmware for them.  A Roku whose firmware version
+// can't be read gets the benefit of the doubt.  The outcome is logged.
+func detectFeatures(cfg *config, info *roku.DeviceInfo) map[string]bool {
+	firmware, firmwareErr := parseVersion(info.SoftwareVersion)
+
+	features := map[string]bool{}
+	var enabled, skipped []string
+	for _, name := range featureNames {
+		reason := ""
+		switch {
+		case (name == featureVolume || name == featureTVInput) && info.IsTv == "false":
+			reason = "not a TV"
+		case cfg.featureFirmware[name] != "" && firmwareErr == nil:
+			min, _ := parseVersion(cfg.featureFirmware[name])
+			if !versionAtLeast(firmware, min) {
+				reason = fmt.Sprintf("firmware %s is older than %s", info.SoftwareVersion, cfg.featureFirmware[name])
+			}
+		}
+
+		if reason != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", name, reason))
+			continue
+		}
+		features[name] = true
+		enabled = append(enabled, name)
+	}
+
+	sort.Strings(enabled)
+	if len(skipped) > 0 {
+		log.Printf("Features for %q: enabled %s; skipped %s", info.UserDeviceName, listOrNone(enabled), strings.Join(skipped, ", "))
+	} else {
+		log.Printf("Features for %q: enabled %s", info.UserDeviceName, listOrNone(enabled))
+	}
+	return features
+}
+
+// wantInput reports whether to add an input for app.
+func (r *Roku) wantInput(app *roku.App) bool {
+	if app.Type == "tvin" && !r.features[featureTVInput] {
+		return false
+	}
+	return r.global.inputTypes[inputType(app)]
+}
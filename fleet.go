@@ -0,0 +1,32 @@
+package main
+
+import "sync"
+
+// fleet counts how many of the Rokus that were set up are reachable,
+// for an overall health signal.
+type fleet struct {
+	mu        sync.Mutex
+	total     int
+	reachable int
+}
+
+// add counts a newly set up Roku, which starts out reachable.
+func (f *fleet) add() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.total++
+	f.reachable++
+}
+
+// changed records a Roku's reachability changing, and returns the new
+// counts.
+func (f *fleet) changed(reachable bool) (n, total int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if reachable {
+		f.reachable++
+	} else {
+		f.reachable--
+	}
+	return f.reachable, f.total
+}
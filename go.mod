@@ -3,8 +3,8 @@ module github.com/joeshaw/roku-homekit
 go 1.15
 
 require (
-	github.com/brutella/hc v1.2.3 // indirect
-	github.com/koron/go-ssdp v0.0.2 // indirect
-	github.com/peterbourgon/ff/v3 v3.0.0 // indirect
-	github.com/picatz/roku v0.0.0-20200817220432-c8242762a377 // indirect
+	github.com/brutella/hc v1.2.3
+	github.com/koron/go-ssdp v0.0.2
+	github.com/peterbourgon/ff/v3 v3.0.0
+	github.com/picatz/roku v0.0.0-20200817220432-c8242762a377
 )
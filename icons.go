@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// icon returns the artwork for an app, and its content type.  Icons
+// are cached in the device's storage directory, since they rarely
+// change and fetching them from the Roku is slow.
+func (r *Roku) icon(appID string) ([]byte, string, error) {
+	if appID == "" || strings.HasPrefix(appID, ".") {
+		return nil, "", fmt.Errorf("invalid app ID %q", appID)
+	}
+
+	dir := filepath.Join(r.storagePath, "icons")
+	path := filepath.Join(dir, filepath.Base(appID))
+
+	b, err := ioutil.ReadFile(path)
+	if err == nil && len(b) > 0 {
+		return b, http.DetectContentType(b), nil
+	}
+
+	b, err = r.endpoint.Icon(appID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		_ = ioutil.WriteFile(path, b, 0644)
+	}
+
+	return b, http.DetectContentType(b), nil
+}
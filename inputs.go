@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+	"github.com/picatz/roku"
+)
+
+// homeIdentifier is the input identifier used for the Roku home
+// screen, which isn't an app and has no ID of its own.  It's also
+// reported when the active app can't be determined, since HomeKit
+// doesn't treat 0 as a valid input.
+const homeIdentifier = 1
+
+// homeApp is the input for the home screen.  Its ID, used for the
+// input in per-device settings and saved state, isn't numeric, so no
+// app a Roku lists can have it.
+var homeApp = &roku.App{
+	ID:   "home",
+	Name: "Home",
+}
+
+// legacyHomeAppID is the ID older versions gave the home screen's
+// input, under which its saved settings may still be kept.
+const legacyHomeAppID = "1"
+
+// The screensaver is reported alongside the active app with this type.
+const screensaverAppType = "ssvr"
+
+// Values for -screensaver-input and -active-app-error-input: which
+// input to report when the Roku isn't showing an app, or when its
+// active app can't be determined.  Either the home screen, or whatever
+// input was active before.
+const (
+	inputHome     = "home"
+	inputPrevious = "previous"
+)
+
+func checkFallbackInput(flag, s string) error {
+	switch s {
+	case inputHome, inputPrevious:
+		return nil
+	default:
+		return fmt.Errorf("invalid -%s %q (must be %s or %s)", flag, s, inputHome, inputPrevious)
+	}
+}
+
+// inputTypes are the kinds of input that can be chosen with
+// -input-types, and their HomeKit input source types.  Roku TVs list
+// their physical inputs alongside apps, with the type "tvin".
+var inputTypes = map[string]int{
+	"application": characteristic.InputSourceTypeApplication,
+	"hdmi":        characteristic.InputSourceTypeHdmi,
+	"tuner":       characteristic.InputSourceTypeTuner,
+	"composite":   characteristic.InputSourceTypeCompositeVideo,
+}
+
+// tvInputPrefix begins the IDs of a Roku TV's physical inputs, like
+// tvinput.hdmi1, tvinput.dtv and tvinput.cvbs.
+const tvInputPrefix = "tvinput."
+
+// inputType returns the kind of input app is, one of the keys of
+// inputTypes.
+func inputType(app *roku.App) string {
+	id := strings.ToLower(app.ID)
+	if app.Type != "tvin" || !strings.HasPrefix(id, tvInputPrefix) {
+		return "application"
+	}
+
+	name := strings.TrimPrefix(id, tvInputPrefix)
+	switch {
+	case strings.HasPrefix(name, "hdmi"):
+		return "hdmi"
+	case strings.HasPrefix(name, "dtv"), strings.HasPrefix(name, "tuner"):
+		return "tuner"
+	case strings.HasPrefix(name, "cvbs"), strings.HasPrefix(name, "av"):
+		return "composite"
+	}
+	return "application"
+}
+
+func parseInputTypes(s string) (map[string]bool, error) {
+	types := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if _, ok := inputTypes[t]; !ok {
+			return nil, fmt.Errorf("unknown input type %q (known: %s)", t, strings.Join(inputTypeNames(), ", "))
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
+func inputTypeNames() []string {
+	names := make([]string, 0, len(inputTypes))
+	for name := range inputTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Roku) addApp(app *roku.App) {
+	// Rokus occasionally list an app twice, and HomeKit doesn't cope
+	// with two inputs for the same thing.
+	if _, ok := r.identifiers[app.ID]; ok {
+		log.Printf("Ignoring duplicate app %q (ID %s) on %q", app.Name, app.ID, r.deviceInfo.UserDeviceName)
+		return
+	}
+
+	input := service.NewInputSource()
+
+	sourceType := inputTypes[inputType(app)]
+	if app == homeApp {
+		sourceType = characteristic.InputSourceTypeHomeScreen
+	}
+
+	name := truncateName(app.Name, r.global.maxInputNameLength)
+	input.Name.SetValue(name)
+	if saved := r.savedName(app.ID); saved != "" {
+		name = saved
+	}
+	input.ConfiguredName.SetValue(name)
+	input.ConfiguredName.OnValueRemoteUpdate(func(name string) {
+		r.saveName(app.ID, name)
+	})
+	input.InputSourceType.SetValue(sourceType)
+	input.IsConfigured.SetValue(characteristic.IsConfiguredConfigured)
+
+	input.Identifier.SetValue(r.assignIdentifier(app))
+	if r.appNames == nil {
+		r.appNames = map[string]string{}
+	}
+	r.appNames[app.ID] = app.Name
+	if r.inputSources == nil {
+		r.inputSources = map[string]*service.InputSource{}
+	}
+	r.inputSources[app.ID] = input
+
+	visibility := characteristic.TargetVisibilityStateShown
+	if !r.cfg.inputVisible(app.ID) || (r.global.inputsHiddenByDefault && app != homeApp && len(r.cfg.VisibleInputs) == 0) {
+		visibility = characteristic.TargetVisibilityStateHidden
+	}
+	if saved := r.savedVisibility(app.ID); saved != nil {
+		visibility = *saved
+	}
+	input.TargetVisibilityState.SetValue(visibility)
+	input.CurrentVisibilityState.SetValue(visibility)
+
+	input.TargetVisibilityState.OnValueRemoteUpdate(func(v int) {
+		input.CurrentVisibilityState.SetValue(v)
+		r.saveVisibility(app.ID, v)
+	})
+
+	r.accessory.AddService(input.Service)
+	r.tv.AddLinkedService(input.Service)
+}
+
+// truncateName shortens name to at most max characters, ending it with
+// an ellipsis, for the Home app's input list.  A max of 0 or less means
+// no limit.
+func truncateName(name string, max int) string {
+	runes := []rune(name)
+	if max <= 0 || len(runes) <= max {
+		return name
+	}
+	if max == 1 {
+		return "…"
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+func (r *Roku) savedVisibility(appID string) *int {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if is := r.state.Inputs[appID]; is != nil {
+		return is.Visibility
+	}
+	return nil
+}
+
+func (r *Roku) saveVisibility(appID string, v int) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	r.state.input(appID).Visibility = &v
+	r.saveStateLocked()
+}
+
+func (r *Roku) savedName(appID string) string {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if is := r.state.Inputs[appID]; is != nil && is.Name != nil {
+		return *is.Name
+	}
+	return ""
+}
+
+func (r *Roku) saveName(appID, name string) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	r.state.input(appID).Name = &name
+	r.saveStateLocked()
+}
+
+// updateInput changes the name shown in the Home app for the input of
+// the app with the given ID, hides or shows it, or both, and saves the
+// changes together.  A nil name or hidden leaves that as it is.
+func (r *Roku) updateInput(appID string, name *string, hidden *bool) error {
+	input := r.inputSources[appID]
+	if input == nil {
+		return fmt.Errorf("no input for app ID %s", appID)
+	}
+
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	is := r.state.input(appID)
+	if name != nil {
+		input.ConfiguredName.SetValue(*name)
+		is.Name = name
+	}
+	if hidden != nil {
+		v := characteristic.TargetVisibilityStateShown
+		if *hidden {
+			v = characteristic.TargetVisibilityStateHidden
+		}
+		input.TargetVisibilityState.SetValue(v)
+		input.CurrentVisibilityState.SetValue(v)
+		is.Visibility = &v
+	}
+	r.saveStateLocked()
+	return nil
+}
+
+func (r *Roku) saveState() {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.saveStateLocked()
+}
+
+// saveStateLocked writes the device's state to disk.  r.stateMu must
+// be held.
+func (r *Roku) saveStateLocked() {
+	if err := r.state.save(r.storagePath); err != nil {
+		log.Printf("Unable to save state for %q: %v", r.deviceInfo.UserDeviceName, err)
+	}
+}
+
+// firstSyntheticIdentifier is the first input identifier handed out to
+// apps whose IDs can't be used as identifiers directly: those that
+// aren't numeric, that are out of range, or that collide with another
+// input.  Numeric app IDs are only used directly below it, so they
+// can't collide with synthetic identifiers saved earlier.  Roku
+// channel IDs are far smaller.
+const firstSyntheticIdentifier = 1 << 24
+
+// maxIdentifier is the largest input identifier.  HomeKit allows any
+// uint32, but identifiers are kept in an int32 (see lastIdentifier).
+const maxIdentifier = math.MaxInt32
+
+// directIdentifier returns the input identifier for an app whose ID
+// can be used as one as is.
+func directIdentifier(appID string) (int, bool) {
+	id, err := strconv.ParseInt(appID, 10, 64)
+	if err != nil || id <= homeIdentifier || id >= firstSyntheticIdentifier {
+		return 0, false
+	}
+	return int(id), true
+}
+
+func validIdentifier(id int) bool {
+	return id > homeIdentifier && id <= maxIdentifier
+}
+
+// assignIdentifier returns the HomeKit input identifier for app.
+// Identifiers are recorded by app ID in the device's state, so that
+// HomeKit scenes and automations using an input keep working across
+// restarts.  If a firmware update changes an app's ID, the record of
+// the uninstalled app with the same name is taken over, so the input
+// keeps its identifier.  Older versions recorded identifiers by app
+// name; those records are moved to the app's ID the first time it's
+// seen.  The caller is responsible for saving the state afterward.
+func (r *Roku) assignIdentifier(app *roku.App) int {
+	if app == homeApp {
+		r.mapInput(homeIdentifier, app.ID)
+		return homeIdentifier
+	}
+
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	saved := r.state.Apps[app.ID]
+	if saved == nil {
+		saved = r.migrateAppState(app)
+	}
+	if saved == nil {
+		saved = r.remapAppState(app)
+	}
+	if saved != nil && validIdentifier(saved.Identifier) && r.inputs[saved.Identifier] == "" {
+		saved.Name = app.Name
+		r.mapInput(saved.Identifier, app.ID)
+		return saved.Identifier
+	}
+
+	id, ok := directIdentifier(app.ID)
+	if !ok || r.inputs[id] != "" {
+		id = r.syntheticIdentifier()
+	}
+
+	if r.state.Apps == nil {
+		r.state.Apps = map[string]*appState{}
+	}
+	r.state.Apps[app.ID] = &appState{ID: app.ID, Name: app.Name, Identifier: id}
+
+	r.mapInput(id, app.ID)
+	return id
+}
+
+// migrateAppState moves app's identifier record from its name, where
+// older versions kept it, to its ID, and returns it.  It returns nil if
+// there's no such record.  r.stateMu must be held.
+func (r *Roku) migrateAppState(app *roku.App) *appState {
+	saved := r.state.Apps[app.Name]
+	if saved == nil || saved.ID == app.Name {
+		// Not a record, or one kept by ID for an app whose ID
+		// happens to be this app's name.
+		return nil
+	}
+
+	r.moveAppState(app.Name, saved, app)
+	return saved
+}
+
+// remapAppState finds the record of an app with app's name that's no
+// longer installed and whose input isn't in use, which is how a
+// firmware update changing the app's ID looks, moves it to app's ID,
+// and returns it.  It returns nil if there's no such record.
+// r.stateMu must be held.
+func (r *Roku) remapAppState(app *roku.App) *appState {
+	installed := map[string]bool{}
+	if r.apps != nil {
+		for _, a := range r.apps.cached() {
+			installed[a.ID] = true
+		}
+	}
+
+	var keys []string
+	for key := range r.state.Apps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		saved := r.state.Apps[key]
+		if saved.Name == app.Name && saved.ID != app.ID && !installed[saved.ID] && r.inputs[saved.Identifier] == "" {
+			r.moveAppState(key, saved, app)
+			return saved
+		}
+	}
+	return nil
+}
+
+// moveAppState moves the record saved under key, along with the input
+// settings of the app it was for, to app's ID.  r.stateMu must be held.
+func (r *Roku) moveAppState(key string, saved *appState, app *roku.App) {
+	if saved.ID != app.ID {
+		log.Printf("App %q on %q changed ID from %s to %s; keeping input identifier %d",
+			app.Name, r.deviceInfo.UserDeviceName, saved.ID, app.ID, saved.Identifier)
+
+		if is := r.state.Inputs[saved.ID]; is != nil {
+			r.state.Inputs[app.ID] = is
+			delete(r.state.Inputs, saved.ID)
+		}
+		saved.ID = app.ID
+	}
+	saved.Name = app.Name
+
+	delete(r.state.Apps, key)
+	r.state.Apps[app.ID] = saved
+}
+
+// syntheticIdentifier returns the first identifier from
+// firstSyntheticIdentifier on that's neither in use nor saved for
+// another app, which may not have been added yet.  r.stateMu must be
+// held.
+func (r *Roku) syntheticIdentifier() int {
+	used := map[int]bool{}
+	for _, a := range r.state.Apps {
+		used[a.Identifier] = true
+	}
+
+	id := firstSyntheticIdentifier
+	for r.inputs[id] != "" || used[id] {
+		id++
+	}
+	return id
+}
+
+func (r *Roku) mapInput(identifier int, appID string) {
+	if r.inputs == nil {
+		r.inputs = map[int]string{}
+		r.identifiers = map[string]int{}
+	}
+	r.inputs[identifier] = appID
+	r.identifiers[appID] = identifier
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/roku"
+)
+
+func TestDirectIdentifier(t *testing.T) {
+	tests := []struct {
+		appID string
+		id    int
+		ok    bool
+	}{
+		{"12", 12, true},
+		{"2", 2, true},
+		{"1", 0, false}, // the home screen's
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"16777215", firstSyntheticIdentifier - 1, true},
+		{"16777216", 0, false},
+		{"2147483647", 0, false},
+		{"2147483648", 0, false},
+		{"4294967296", 0, false},
+		{"99999999999999999999", 0, false},
+		{"dev", 0, false},
+		{"tvinput.hdmi1", 0, false},
+		{"", 0, false},
+		{" 12", 0, false},
+	}
+
+	for _, tt := range tests {
+		id, ok := directIdentifier(tt.appID)
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("directIdentifier(%q) = %d, %t; want %d, %t", tt.appID, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestInputType(t *testing.T) {
+	tests := []struct {
+		app  roku.App
+		want string
+	}{
+		{roku.App{ID: "tvinput.hdmi1", Type: "tvin"}, "hdmi"},
+		{roku.App{ID: "tvinput.dtv", Type: "tvin"}, "tuner"},
+		{roku.App{ID: "tvinput.cvbs", Type: "tvin"}, "composite"},
+		{roku.App{ID: "tvinput.av1", Type: "tvin"}, "composite"},
+		{roku.App{ID: "tvinput.dvb-travel", Type: "tvin"}, "application"},
+		{roku.App{ID: "navhdmi", Type: "tvin"}, "application"},
+		{roku.App{ID: "tvinput.hdmi1", Type: "appl"}, "application"},
+	}
+
+	for _, tt := range tests {
+		if got := inputType(&tt.app); got != tt.want {
+			t.Errorf("inputType(%s, %s) = %s, want %s", tt.app.ID, tt.app.Type, got, tt.want)
+		}
+	}
+}
+
+func TestParseActiveApp(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       roku.App
+	}{
+		{
+			"app",
+			`<?xml version="1.0" encoding="UTF-8" ?>
+<active-app>
+	<app id="12" type="appl" version="4.2.81179021">Netflix</app>
+</active-app>`,
+			roku.App{ID: "12", Type: "appl", Version: "4.2.81179021", Name: "Netflix"},
+		},
+		{
+			"screensaver",
+			`<?xml version="1.0" encoding="UTF-8" ?>
+<active-app>
+	<app>Roku</app>
+	<screensaver id="55545" type="ssvr" version="2.0.1">Default screensaver</screensaver>
+</active-app>`,
+			roku.App{ID: "55545", Type: screensaverAppType, Version: "2.0.1", Name: "Default screensaver"},
+		},
+	}
+
+	for _, tt := range tests {
+		app, err := parseActiveApp(strings.NewReader(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if *app != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *app, tt.want)
+		}
+	}
+}
+
+func TestAssignIdentifierFallsBack(t *testing.T) {
+	r := &Roku{
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		state: &deviceState{Apps: map[string]*appState{
+			// Saved earlier for an app that hasn't been added yet.
+			"Saved": {ID: "saved", Identifier: firstSyntheticIdentifier},
+			// Out of range, from a bad state file.
+			"Bad": {ID: "bad", Identifier: -1},
+		}},
+	}
+
+	apps := []*roku.App{
+		{ID: "12", Name: "Netflix"},
+		{ID: "99999999999999999999", Name: "Huge"},
+		{ID: "12", Name: "Netflix Again"},
+		{ID: "bad", Name: "Bad"},
+		{ID: "saved", Name: "Saved"},
+	}
+
+	ids := map[int]string{}
+	for _, app := range apps {
+		id := r.assignIdentifier(app)
+		if !validIdentifier(id) {
+			t.Errorf("%s got invalid identifier %d", app.Name, id)
+		}
+		if other, ok := ids[id]; ok {
+			t.Errorf("%s and %s both got identifier %d", other, app.Name, id)
+		}
+		ids[id] = app.Name
+	}
+
+	if ids[12] != "Netflix" {
+		t.Errorf("identifier 12 is %q, want Netflix", ids[12])
+	}
+	if ids[firstSyntheticIdentifier] != "Saved" {
+		t.Errorf("identifier %d is %q, want Saved", firstSyntheticIdentifier, ids[firstSyntheticIdentifier])
+	}
+}
+
+func TestHomeAppIsSeparateFromChannelOne(t *testing.T) {
+	r := &Roku{
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		state:      &deviceState{},
+	}
+
+	if id := r.assignIdentifier(homeApp); id != homeIdentifier {
+		t.Errorf("Home got identifier %d, want %d", id, homeIdentifier)
+	}
+	one := &roku.App{ID: "1", Name: "Channel One"}
+	if id := r.assignIdentifier(one); id == homeIdentifier || !validIdentifier(id) {
+		t.Errorf("channel 1 got identifier %d", id)
+	}
+	if r.inputs[homeIdentifier] != homeApp.ID {
+		t.Errorf("identifier %d is app %q, want %q", homeIdentifier, r.inputs[homeIdentifier], homeApp.ID)
+	}
+}
+
+func TestActiveIdentifierBounds(t *testing.T) {
+	apps := []*roku.App{
+		{ID: "12", Name: "Netflix"},
+		{ID: "16777215", Name: "Largest Direct"},
+		{ID: "16777216", Name: "Smallest Synthetic"},
+		{ID: "2147483648", Name: "Past Int32"},
+		{ID: "99999999999999999999", Name: "Huge"},
+	}
+
+	f := &fakeRoku{}
+	r := &Roku{
+		endpoint:   f,
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:     &config{},
+		state:      &deviceState{},
+	}
+	for _, app := range apps {
+		r.assignIdentifier(app)
+	}
+
+	tests := []struct {
+		appID string
+		want  int
+	}{
+		{"", homeIdentifier},
+		{"12", 12},
+		{"16777215", firstSyntheticIdentifier - 1},
+		{"16777216", firstSyntheticIdentifier},
+		{"2147483648", firstSyntheticIdentifier + 1},
+		{"99999999999999999999", firstSyntheticIdentifier + 2},
+		// Not inputs, so HomeKit has no such identifier.
+		{"13", homeIdentifier},
+		{"2147483647", homeIdentifier},
+		{"4294967308", homeIdentifier},
+		{"dev", homeIdentifier},
+	}
+
+	for _, tt := range tests {
+		f.activeApps = []string{tt.appID}
+		if id := r.activeIdentifier(); id != tt.want {
+			t.Errorf("active app %q: identifier %d, want %d", tt.appID, id, tt.want)
+		}
+	}
+}
+
+func TestAssignIdentifierMigratesState(t *testing.T) {
+	r := &Roku{
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		state: &deviceState{
+			Apps: map[string]*appState{
+				// Kept by name, as older versions did.
+				"Netflix": {ID: "12", Identifier: firstSyntheticIdentifier},
+				"Hulu":    {ID: "2285", Identifier: 2285},
+			},
+			Inputs: map[string]*inputState{"2285": {}},
+		},
+	}
+
+	tests := []struct {
+		app  *roku.App
+		want int
+	}{
+		{&roku.App{ID: "12", Name: "Netflix"}, firstSyntheticIdentifier},
+		{&roku.App{ID: "3000", Name: "Hulu"}, 2285}, // ID changed
+		{&roku.App{ID: "13", Name: "Netflix"}, 13},  // same name, different app
+	}
+	for _, tt := range tests {
+		if id := r.assignIdentifier(tt.app); id != tt.want {
+			t.Errorf("%s (%s) got identifier %d, want %d", tt.app.Name, tt.app.ID, id, tt.want)
+		}
+	}
+
+	for key, want := range map[string]int{"12": firstSyntheticIdentifier, "3000": 2285, "13": 13} {
+		if a := r.state.Apps[key]; a == nil || a.ID != key || a.Identifier != want {
+			t.Errorf("state for %s is %+v, want identifier %d", key, a, want)
+		}
+	}
+	if len(r.state.Apps) != 3 {
+		t.Errorf("state has %d apps, want 3", len(r.state.Apps))
+	}
+	if r.state.Inputs["3000"] == nil || r.state.Inputs["2285"] != nil {
+		t.Errorf("input settings weren't moved to the new ID: %v", r.state.Inputs)
+	}
+}
+
+func TestAssignIdentifierFollowsIDChanges(t *testing.T) {
+	state := &deviceState{
+		Apps: map[string]*appState{
+			// Kept by name, as older versions did.
+			"Hulu": {ID: "2285", Identifier: 2285},
+		},
+		Inputs: map[string]*inputState{"2285": {}},
+	}
+
+	// Each firmware update gives Hulu a new ID, and the accessory is
+	// set up again with the new app list.
+	for _, apps := range []roku.Apps{
+		{{ID: "3000", Name: "Hulu"}, {ID: "12", Name: "Netflix"}},
+		{{ID: "4000", Name: "Hulu"}, {ID: "12", Name: "Netflix"}},
+	} {
+		r := &Roku{
+			deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+			state:      state,
+			apps:       newAppCache(time.Minute, nil),
+		}
+		r.apps.set(apps)
+
+		for _, app := range apps {
+			r.assignIdentifier(app)
+		}
+
+		hulu := apps[0]
+		if id := r.identifiers[hulu.ID]; id != 2285 {
+			t.Errorf("Hulu (%s) got identifier %d, want 2285", hulu.ID, id)
+		}
+		if a := state.Apps[hulu.ID]; a == nil || a.Identifier != 2285 || a.Name != "Hulu" {
+			t.Errorf("state for Hulu (%s) is %+v", hulu.ID, a)
+		}
+		if state.Inputs[hulu.ID] == nil {
+			t.Errorf("input settings weren't moved to Hulu's new ID %s: %v", hulu.ID, state.Inputs)
+		}
+		if len(state.Apps) != 2 {
+			t.Errorf("state has %d apps, want 2: %v", len(state.Apps), state.Apps)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// inventoryEntry describes a Roku that was set up, for the startup
+// summary and -inventory-file.
+type inventoryEntry struct {
+	Name     string `json:"name"`
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+	Address  string `json:"address"`
+	Apps     int    `json:"apps"`
+}
+
+func (r *Roku) inventory() inventoryEntry {
+	return inventoryEntry{
+		Name:     r.deviceInfo.UserDeviceName,
+		Serial:   r.deviceInfo.SerialNumber,
+		Model:    r.deviceInfo.ModelNumber,
+		Firmware: r.deviceInfo.SoftwareVersion + "-" + r.deviceInfo.SoftwareBuild,
+		Address:  r.endpoint.host(),
+		Apps:     len(r.apps.cached()),
+	}
+}
+
+// logInventory logs a line for each Roku that was set up, and writes
+// them all as JSON to path if it isn't empty.
+func logInventory(rokus []*Roku, path string) {
+	entries := []inventoryEntry{}
+	for _, r := range rokus {
+		e := r.inventory()
+		log.Printf("Found %q: serial %s, model %s, firmware %s, address %s, %d apps",
+			e.Name, e.Serial, e.Model, e.Firmware, e.Address, e.Apps)
+		entries = append(entries, e)
+	}
+
+	if path == "" {
+		return
+	}
+
+	if err := writeInventory(path, entries); err != nil {
+		log.Printf("Unable to write inventory: %v", err)
+	}
+}
+
+func writeInventory(path string, entries []inventoryEntry) error {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/picatz/roku"
+)
+
+// powerOnKey turns on a Roku TV.  The roku package doesn't have this,
+// oddly.
+const powerOnKey = "PowerOn"
+
+// knownKeys are the ECP key names accepted in configuration.
+var knownKeys = []string{
+	roku.HomeKey,
+	roku.RevKey,
+	roku.FwdKey,
+	roku.PlayKey,
+	roku.SelectKey,
+	roku.LeftKey,
+	roku.RightKey,
+	roku.DownKey,
+	roku.UpKey,
+	roku.BackKey,
+	roku.InstantReplayKey,
+	roku.InfoKey,
+	roku.BackspaceKey,
+	roku.SearchKey,
+	roku.EnterKey,
+	roku.FindRemoteKey,
+	roku.VolumeDownKey,
+	roku.VolumeMuteKey,
+	roku.VolumeUpKey,
+	roku.PowerOffKey,
+	powerOnKey,
+	"Power",
+	roku.ChannelUpKey,
+	roku.ChannelDownKey,
+	roku.InputTunerKey,
+	roku.InputHDMI1Key,
+	roku.InputHDMI2Key,
+	roku.InputHDMI3Key,
+	roku.InputHDMI4Key,
+	roku.InputAV1Key,
+}
+
+// keyAliases are other names accepted for keys, matching what's
+// printed on the remote.
+var keyAliases = map[string]string{
+	"options": roku.InfoKey,
+	"replay":  roku.InstantReplayKey,
+}
+
+// keyLabels name the buttons for keys whose ECP names don't match the
+// remote.  Other buttons are named after their keys.
+var keyLabels = map[string]string{
+	roku.InfoKey:          "Options",
+	roku.InstantReplayKey: "Instant Replay",
+}
+
+func keyLabel(key string) string {
+	if label := keyLabels[key]; label != "" {
+		return label
+	}
+	return key
+}
+
+// tvOnlyKeys only do anything on Roku TVs, not on streaming players.
+var tvOnlyKeys = map[string]bool{
+	roku.VolumeDownKey:  true,
+	roku.VolumeMuteKey:  true,
+	roku.VolumeUpKey:    true,
+	roku.ChannelUpKey:   true,
+	roku.ChannelDownKey: true,
+	roku.InputTunerKey:  true,
+	roku.InputHDMI1Key:  true,
+	roku.InputHDMI2Key:  true,
+	roku.InputHDMI3Key:  true,
+	roku.InputHDMI4Key:  true,
+	roku.InputAV1Key:    true,
+}
+
+// supportsKey reports whether the Roku described by deviceInfo does
+// anything with key.  ECP has no way to ask, so this goes by what the
+// device info says about the device.  Fields missing from older
+// firmware are taken to mean the key is supported.
+func supportsKey(deviceInfo *roku.DeviceInfo, key string) bool {
+	switch {
+	case key == roku.FindRemoteKey:
+		return deviceInfo.SupportsFindRemote != "false"
+	case tvOnlyKeys[key]:
+		return deviceInfo.IsTv != "false"
+	}
+	return true
+}
+
+// canonicalKey returns the ECP name for key, matched
+// case-insensitively against knownKeys and keyAliases.
+func canonicalKey(key string) (string, error) {
+	if k, ok := keyAliases[strings.ToLower(key)]; ok {
+		return k, nil
+	}
+	for _, k := range knownKeys {
+		if strings.EqualFold(k, key) {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key %q", key)
+}
+
+// maxRawKeyLength is longer than any ECP key name.
+const maxRawKeyLength = 64
+
+// rawKey checks a key name that isn't necessarily one of knownKeys,
+// for sending straight to the Roku.  Key names are letters, digits
+// and underscores, except for Lit_ keys, which type a single
+// character; that character is escaped for the URL.
+func rawKey(key string) (string, error) {
+	if strings.HasPrefix(key, "Lit_") {
+		c := strings.TrimPrefix(key, "Lit_")
+		if utf8.RuneCountInString(c) != 1 {
+			return "", fmt.Errorf("invalid key %q: Lit_ must be followed by a single character", key)
+		}
+		return "Lit_" + url.PathEscape(c), nil
+	}
+
+	if key == "" || len(key) > maxRawKeyLength {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return "", fmt.Errorf("invalid key %q: key names are letters, digits and underscores", key)
+		}
+	}
+	return key, nil
+}
+
+// parseKeys parses a comma-separated list of key names.
+func parseKeys(s string) ([]string, error) {
+	var keys []string
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+
+		key, err := canonicalKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// kioskConfig cycles a Roku through Apps, launching the next one every
+// Interval, for store displays and the like.
+type kioskConfig struct {
+	Apps     []string `json:"apps"`
+	Interval string   `json:"interval"`
+	interval time.Duration
+}
+
+func (kc *kioskConfig) validate() error {
+	if len(kc.Apps) == 0 {
+		return errors.New("apps must not be empty")
+	}
+
+	d, err := time.ParseDuration(kc.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval: %w", err)
+	}
+	if d < 10*time.Second {
+		return errors.New("interval must be at least 10s")
+	}
+	kc.interval = d
+
+	return nil
+}
+
+// kiosk runs a Roku's kiosk cycle.  The zero value is stopped.
+type kiosk struct {
+	mu   sync.Mutex
+	stop chan struct{} // nil when stopped
+	sw   *service.Switch
+}
+
+// addKiosk adds a "Kiosk" switch that starts and stops the cycle.
+func (r *Roku) addKiosk() {
+	sw := service.NewSwitch()
+
+	name := characteristic.NewName()
+	name.SetValue("Kiosk")
+	sw.AddCharacteristic(name.Characteristic)
+
+	sw.On.OnValueRemoteUpdate(func(on bool) {
+		if on {
+			r.startKiosk()
+		} else {
+			r.stopKiosk()
+		}
+	})
+
+	r.kiosk.sw = sw
+	r.accessory.AddService(sw.Service)
+}
+
+// startKiosk starts the kiosk cycle, if it isn't already running.
+func (r *Roku) startKiosk() {
+	r.kiosk.mu.Lock()
+	defer r.kiosk.mu.Unlock()
+
+	if r.kiosk.stop != nil {
+		return
+	}
+
+	log.Printf("Starting kiosk cycle on %q", r.deviceInfo.UserDeviceName)
+	r.kiosk.stop = make(chan struct{})
+	r.kiosk.sw.On.SetValue(true)
+	go r.runKiosk(r.kiosk.stop)
+}
+
+// stopKiosk stops the kiosk cycle, leaving the current app running.
+func (r *Roku) stopKiosk() {
+	r.kiosk.mu.Lock()
+	defer r.kiosk.mu.Unlock()
+
+	if r.kiosk.stop == nil {
+		return
+	}
+
+	log.Printf("Stopping kiosk cycle on %q", r.deviceInfo.UserDeviceName)
+	close(r.kiosk.stop)
+	r.kiosk.stop = nil
+	r.kiosk.sw.On.SetValue(false)
+}
+
+func (r *Roku) kioskRunning() bool {
+	r.kiosk.mu.Lock()
+	defer r.kiosk.mu.Unlock()
+	return r.kiosk.stop != nil
+}
+
+func (r *Roku) runKiosk(stop chan struct{}) {
+	kc := r.cfg.Kiosk
+
+	for i := 0; ; i = (i + 1) % len(kc.Apps) {
+		// While paused, the cycle keeps time but doesn't launch
+		// anything.
+		if !r.global.pause.isPaused() {
+			r.kioskLaunch(kc.Apps[i])
+		}
+
+		select {
+		case <-time.After(kc.interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// kioskLaunch launches the app with the given ID for the kiosk cycle.
+// It waits for any launch or input change from HomeKit in progress, but
+// an input picked in the Home app only lasts until the next one.
+func (r *Roku) kioskLaunch(id string) {
+	r.launchMu.Lock()
+	err := r.endpoint.LaunchApp(id, nil)
+	r.launchMu.Unlock()
+
+	r.audit(auditKiosk, "launch "+id, err)
+	if err != nil {
+		log.Printf("Kiosk couldn't launch app ID %s on %q: %v", id, r.deviceInfo.UserDeviceName, err)
+		return
+	}
+	r.countLaunch(id)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brutella/hc/service"
+	"github.com/picatz/roku"
+)
+
+// slowLauncher is a controller whose launches and keypresses take a
+// while, and which records whether any of them overlapped.
+type slowLauncher struct {
+	fakeRoku
+
+	inFlight   int32
+	overlapped int32
+	launches   int32
+}
+
+func (s *slowLauncher) command() error {
+	if atomic.AddInt32(&s.inFlight, 1) > 1 {
+		atomic.StoreInt32(&s.overlapped, 1)
+	}
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt32(&s.inFlight, -1)
+	atomic.AddInt32(&s.launches, 1)
+	return nil
+}
+
+func (s *slowLauncher) LaunchApp(string, map[string]string) error { return s.command() }
+func (s *slowLauncher) Keypress(string) error                     { return s.command() }
+
+func TestKioskLaunchesAreSerialized(t *testing.T) {
+	s := &slowLauncher{}
+	r := &Roku{
+		endpoint:    s,
+		deviceInfo:  &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:      &config{},
+		cfg:         &deviceConfig{},
+		inputs:      map[int]string{12: "12"},
+		identifiers: map[string]int{"12": 12},
+		tv:          service.NewTelevision(),
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.kioskLaunch("837")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.setActiveIdentifier(12)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.setActiveIdentifier(homeIdentifier)
+		}
+	}()
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&s.launches); got != 3*n {
+		t.Errorf("%d commands sent, want %d", got, 3*n)
+	}
+	if atomic.LoadInt32(&s.overlapped) != 0 {
+		t.Error("kiosk launches and HomeKit input changes overlapped")
+	}
+}
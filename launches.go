@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// launchCounter counts the apps launched on a Roku, by app ID.  The
+// zero value is ready to use.
+type launchCounter struct {
+	mu     sync.Mutex
+	counts map[string]*launchCount
+}
+
+type launchCount struct {
+	id, name string
+	n        int
+}
+
+func (c *launchCounter) add(id, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = map[string]*launchCount{}
+	}
+	lc := c.counts[id]
+	if lc == nil {
+		lc = &launchCount{id: id}
+		c.counts[id] = lc
+	}
+	if name != "" {
+		lc.name = name
+	}
+	lc.n++
+}
+
+// all returns the counts, ordered by app ID.
+func (c *launchCounter) all() []launchCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make([]launchCount, 0, len(c.counts))
+	for _, lc := range c.counts {
+		counts = append(counts, *lc)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].id < counts[j].id })
+	return counts
+}
+
+// countLaunch records a successful launch of the app with the given
+// ID.  Apps without an input are named from the cached app list.
+func (r *Roku) countLaunch(id string) {
+	name := r.appNames[id]
+	if name == "" && r.apps != nil {
+		for _, app := range r.apps.cached() {
+			if app.ID == id {
+				name = app.Name
+				break
+			}
+		}
+	}
+	r.launches.add(id, name)
+}
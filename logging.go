@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	hclog "github.com/brutella/hc/log"
+)
+
+// setupLogging sends our log output, and hc's, to a rotating file
+// and/or syslog instead of stderr.
+func setupLogging(cfg *config, file string, maxSize int64, backups int, syslogTag string) error {
+	var writers []io.Writer
+
+	if file != "" {
+		f, err := newRotatingFile(file, maxSize, backups)
+		if err != nil {
+			return err
+		}
+		writers = append(writers, f)
+	}
+
+	if syslogTag != "" {
+		w, err := newSyslogWriter(syslogTag)
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog: %w", err)
+		}
+		writers = append(writers, w)
+	}
+
+	if len(writers) == 0 {
+		return nil
+	}
+
+	w := io.MultiWriter(writers...)
+	log.SetOutput(w)
+	hclog.Info.SetOutput(w)
+	if cfg.debug {
+		hclog.Debug.SetOutput(w)
+	}
+
+	return nil
+}
+
+// rotatingFile is a log file that is rotated once it grows past
+// maxSize bytes, keeping up to backups old files named path.1,
+// path.2, and so on.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			// Keep writing to the current file rather than losing
+			// log output.
+			fmt.Fprintf(os.Stderr, "Unable to rotate %s: %v\n", rf.path, err)
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+
+	var err error
+	for i := rf.backups; i > 0 && err == nil; i-- {
+		from := rf.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", rf.path, i-1)
+		}
+		to := fmt.Sprintf("%s.%d", rf.path, i)
+
+		if e := os.Rename(from, to); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+
+	if rf.backups == 0 {
+		if e := os.Remove(rf.path); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+
+	// Reopen even if renaming failed, so that we have somewhere to
+	// write.
+	if e := rf.open(); e != nil {
+		return e
+	}
+
+	return err
+}
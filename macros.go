@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+)
+
+// remoteKeyNames names the keys of HomeKit's remote, for configuring
+// macros.
+var remoteKeyNames = map[string]int{
+	"rewind":         characteristic.RemoteKeyRewind,
+	"fast_forward":   characteristic.RemoteKeyFastForward,
+	"next_track":     characteristic.RemoteKeyNextTrack,
+	"previous_track": characteristic.RemoteKeyPrevTrack,
+	"up":             characteristic.RemoteKeyArrowUp,
+	"down":           characteristic.RemoteKeyArrowDown,
+	"left":           characteristic.RemoteKeyArrowLeft,
+	"right":          characteristic.RemoteKeyArrowRight,
+	"select":         characteristic.RemoteKeySelect,
+	"back":           characteristic.RemoteKeyBack,
+	"exit":           characteristic.RemoteKeyExit,
+	"play_pause":     characteristic.RemoteKeyPlayPause,
+	"info":           characteristic.RemoteKeyInfo,
+}
+
+// parseRemoteMacros checks macros, which map HomeKit remote key names
+// to sequences of Roku keys, and returns them keyed by HomeKit remote
+// key, with the Roku key names canonicalized.
+func parseRemoteMacros(macros map[string][]string) (map[int][]string, error) {
+	parsed := map[int][]string{}
+	for name, keys := range macros {
+		k, ok := remoteKeyNames[strings.ToLower(name)]
+		if !ok {
+			var names []string
+			for n := range remoteKeyNames {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown remote key %q (known: %s)", name, strings.Join(names, ", "))
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("macro for %q is empty", name)
+		}
+
+		seq := make([]string, len(keys))
+		for i, key := range keys {
+			c, err := canonicalKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("macro for %q: %w", name, err)
+			}
+			seq[i] = c
+		}
+		parsed[k] = seq
+	}
+	return parsed, nil
+}
+
+// sendKeys sends keys to the Roku in order, stopping at the first
+// error.
+func (r *Roku) sendKeys(keys []string) error {
+	for i, key := range keys {
+		if i > 0 {
+			// Keys sent back to back are sometimes dropped.
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		if err := r.endpoint.Keypress(key); err != nil {
+			return fmt.Errorf("sending %q: %w", key, err)
+		}
+	}
+	return nil
+}
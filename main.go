@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/brutella/hc"
@@ -21,18 +28,134 @@ import (
 )
 
 type Roku struct {
-	endpoint   *roku.Endpoint
+	endpoint   controller
 	deviceInfo *roku.DeviceInfo
+	global     *config
+	cfg        *deviceConfig
+
+	storagePath string
+	stateMu     sync.Mutex
+	state       *deviceState
+
+	// inputs maps HomeKit input identifiers to app IDs, and
+	// identifiers is the reverse.  They're only written during setup.
+	inputs       map[int]string
+	identifiers  map[string]int
+	appNames     map[string]string
+	inputSources map[string]*service.InputSource // keyed by app ID
+
+	// launchMu serializes launches and input changes, from HomeKit,
+	// the API and the kiosk cycle, so they don't interleave.
+	launchMu sync.Mutex
+	launches launchCounter
+	cooldown powerCooldown
+
+	// features are the ones enabled for this Roku.
+	features map[string]bool
+	kiosk    kiosk
+	volume   volumeProxy
+
+	// lastIdentifier is the most recently reported active identifier,
+	// accessed atomically.
+	lastIdentifier int32
+
+	// latestMu guards latest, the most recently fetched device info.
+	latestMu sync.Mutex
+	latest   *roku.DeviceInfo
+
+	// lastCommandMu guards lastCommand, the most recent command sent
+	// to the Roku.
+	lastCommandMu sync.Mutex
+	lastCommand   *auditEntry
 
 	accessory *accessory.Accessory
 	tv        *service.Television
-	transport hc.Transport
+	hcConfig  hc.Config
+
+	transportMu    sync.Mutex
+	transport      hc.Transport
+	transportState int
+	transportDone  chan struct{} // closed when runTransport returns
+
+	webhook  *webhook
+	reach    *reachability
+	apps     *appCache
+	selfTest selfTest
 }
 
 type config struct {
-	storagePath string
-	homekitPIN  string
-	debug       bool
+	storagePath   string
+	storageLayout *template.Template
+	nameTemplate  *template.Template
+	homekitPIN    string
+	uniquePINs    bool
+	debug         bool
+	devices       map[string]*deviceConfig
+	defaultDevice *deviceConfig
+	devicesConfig string // the file devices and defaultDevice came from
+	timeouts      ecpTimeouts
+	inflight      chan struct{}
+	webhook       *webhook
+	audit         *auditLog
+	maxDevices    int
+	appsAttempts  int
+	buttonKeys    []string
+	settingsKeys  []string
+
+	inputsHiddenByDefault bool
+
+	deviceInfoAttempts int
+
+	deviceInfoFields    []string
+	managed             map[string]bool
+	featureFirmware     map[string]string
+	onReconnect         map[string]bool
+	macHardwareRevision bool
+
+	noSSDP        bool
+	rokuAddresses []string
+	stableIDs     map[string]string
+	scanCIDRs     []*net.IPNet
+	scanTimeout   time.Duration
+
+	pollInterval time.Duration
+
+	launchVerifyDelay time.Duration
+	powerCooldown     time.Duration
+
+	powerOnAppRetries    int
+	powerOnAppRetryDelay time.Duration
+
+	powerConfirmPolls    int
+	powerConfirmInterval time.Duration
+
+	sleepDiscoveryMode int
+	screensaverInput   string
+	errorInput         string
+	unknownInput       string
+	tvCharacteristics  map[string]bool
+	inputTypes         map[string]bool
+	maxInputNameLength int
+
+	retryRandomPort     bool
+	restartTransport    bool
+	resetCorruptStorage bool
+	portBase            int
+	allowReboot         bool
+	volumeAsBrightness  bool
+
+	installMissingApps bool
+
+	unreachableAfter int
+	reachableAfter   int
+	unreachableGrace time.Duration
+	fleet            fleet
+	pause            pauser
+
+	appsCacheTTL      time.Duration
+	findRemoteRetries int
+	noIdentifyRemote  bool
+	selfTestInterval  time.Duration
 }
 
 func main() {
@@ -45,62 +168,428 @@ func main() {
 		filepath.Join(os.Getenv("HOME"), ".homecontrol", "roku"),
 		"Storage path for information about the HomeKit accessory",
 	)
+	storageLayout := fs.String(
+		"storage-layout",
+		defaultStorageLayout,
+		"Template for each device's storage directory, relative to the storage path",
+	)
+	nameTemplate := fs.String("name-template", defaultNameTemplate, "Template for each accessory's name, evaluated against the Roku's device info")
 	fs.StringVar(&cfg.homekitPIN, "homekit-pin", "00102003", "HomeKit pairing PIN")
+	fs.BoolVar(&cfg.inputsHiddenByDefault, "inputs-hidden-by-default", false, "Add apps not listed in visible_inputs as hidden inputs, to be shown from the Home app or the HTTP API")
+	fs.BoolVar(&cfg.uniquePINs, "unique-pins", false, "Derive a different HomeKit PIN for each Roku from -homekit-pin, and log them")
+	homekitPINFile := fs.String("homekit-pin-file", "", "File to read the HomeKit pairing PIN from, instead of -homekit-pin")
 	fs.BoolVar(&cfg.debug, "debug", false, "Enable debug mode")
+	fs.DurationVar(&cfg.timeouts.query, "query-timeout", 3*time.Second, "Timeout for ECP queries such as device info and the active app")
+	fs.DurationVar(&cfg.timeouts.keypress, "keypress-timeout", 3*time.Second, "Timeout for ECP keypresses")
+	fs.DurationVar(&cfg.timeouts.launch, "launch-timeout", 15*time.Second, "Timeout for launching apps over ECP")
+	maxInflight := fs.Int("max-inflight", 0, "Maximum number of ECP requests to make at once, across all Rokus (0 for no limit)")
+	webhookURL := fs.String("webhook-url", "", "URL to POST device state changes to")
+	webhookDebounce := fs.Duration("webhook-debounce", 2*time.Second, "Coalesce state changes within this window into one webhook; changes that end where they started aren't sent")
+	webhookRetries := fs.Int("webhook-retries", 3, "Number of times to retry a failed webhook")
+	fs.IntVar(&cfg.maxDevices, "max-devices", 0, "Maximum number of Rokus to set up (0 for no limit)")
+	testKey := fs.String("test-key", "", "Send this key to -test-device after discovery, then exit")
+	testDevice := fs.String("test-device", "", "Serial number of the Roku to send -test-key to")
+	fs.IntVar(&cfg.appsAttempts, "apps-attempts", 3, "Number of times to try fetching a Roku's apps at startup")
+	fs.IntVar(&cfg.deviceInfoAttempts, "device-info-attempts", 3, "Number of times to try fetching each discovered Roku's device info")
+	buttonKeys := fs.String("button-keys", "", "Comma-separated keys to expose as buttons, e.g. Up,Down,Left,Right,Select,Back")
+	settingsKeys := fs.String("tv-settings-keys", "", "Comma-separated keys to send when \"View TV Settings\" is chosen in the Home app, e.g. Home or Info")
+	logFile := fs.String("log-file", "", "Write logs to this file instead of stderr")
+	logFileSize := fs.Int64("log-file-size", 10*1024*1024, "Rotate the log file once it reaches this many bytes")
+	logFileBackups := fs.Int("log-file-backups", 3, "Number of rotated log files to keep")
+	auditLogPath := fs.String("audit-log", "", "Append a record of each command sent to a Roku to this file")
+	logSyslog := fs.String("log-syslog", "", "Send logs to syslog with this tag")
+	pausedCommands := fs.String("paused-commands", "drop", "What to do with commands from HomeKit while paused: drop or queue")
+	fs.DurationVar(&cfg.pollInterval, "poll-interval", 10*time.Second, "How often to poll each Roku's power state and active app")
+	fs.DurationVar(&cfg.launchVerifyDelay, "launch-verify-delay", time.Second, "How long to wait after launching an app before checking that it's active")
+	fs.IntVar(&cfg.powerOnAppRetries, "power-on-app-retries", 2, "Number of times to retry launching the power-on app if it doesn't come up")
+	fs.DurationVar(&cfg.powerOnAppRetryDelay, "power-on-app-retry-delay", 5*time.Second, "How long to wait between power-on app launch attempts")
+	fs.DurationVar(&cfg.powerCooldown, "power-cooldown", 0, "Hold back power commands from HomeKit until this long after the last one (0 to disable)")
+	fs.IntVar(&cfg.powerConfirmPolls, "power-confirm-polls", 5, "Number of quick polls to confirm a power change from HomeKit")
+	fs.DurationVar(&cfg.powerConfirmInterval, "power-confirm-interval", time.Second, "Interval between power confirmation polls")
+	sleepDiscoveryMode := fs.String("sleep-discovery-mode", "always", "Whether HomeKit can discover Rokus that are off: always or never")
+	fs.StringVar(&cfg.screensaverInput, "screensaver-input", inputHome, "Input to report while the screensaver is on: home or previous")
+	fs.StringVar(&cfg.unknownInput, "unknown-input", "", "App ID, or home, to switch to when HomeKit picks an input that no longer exists (default none)")
+	fs.StringVar(&cfg.errorInput, "active-app-error-input", inputPrevious, "Input to report when the active app can't be determined: home or previous")
+	inputTypes := fs.String("input-types", strings.Join(inputTypeNames(), ","), "Kinds of input to add: "+strings.Join(inputTypeNames(), ", "))
+	fs.IntVar(&cfg.maxInputNameLength, "max-input-name-length", 0, "Truncate input names in the Home app to this many characters (0 for no limit)")
+	tvCharacteristics := fs.String(
+		"tv-characteristics",
+		defaultTVCharacteristics,
+		"Optional television characteristics to expose: "+strings.Join(optionalCharacteristics, ", "),
+	)
+	fs.IntVar(&cfg.portBase, "port-base", 0, "First port for HomeKit accessories, one per Roku (0 for random ports)")
+	transportStagger := fs.Duration("transport-stagger", 0, "Wait this long between starting each Roku's HomeKit accessory")
+	fs.BoolVar(&cfg.resetCorruptStorage, "reset-corrupt-storage", false, "Back up and reinitialize a Roku's HomeKit storage if it's corrupt, which requires pairing again")
+	fs.BoolVar(&cfg.retryRandomPort, "retry-random-port", false, "If a HomeKit accessory can't listen on its port, retry on a random one")
+	fs.BoolVar(&cfg.restartTransport, "restart-transport", false, "Restart a HomeKit accessory's transport a few times if it panics")
+	fs.BoolVar(&cfg.installMissingApps, "install-missing-apps", false, "When asked to launch an app that isn't installed, show its channel store page")
+	fs.BoolVar(&cfg.volumeAsBrightness, "volume-as-brightness", false, "Add a lightbulb to each Roku TV whose brightness sets the volume (a workaround; see README)")
+	fs.BoolVar(&cfg.allowReboot, "allow-reboot", false, "Add a switch to reboot each Roku")
+	fs.IntVar(&cfg.unreachableAfter, "unreachable-after", 3, "Consider a Roku unreachable after this many consecutive failed polls")
+	fs.IntVar(&cfg.reachableAfter, "reachable-after", 1, "Consider an unreachable Roku reachable again after this many consecutive successful polls")
+	fs.DurationVar(&cfg.unreachableGrace, "unreachable-grace", 0, "Also wait this long after polls start failing before considering a Roku unreachable")
+	fs.DurationVar(&cfg.appsCacheTTL, "apps-cache-ttl", 5*time.Minute, "How long to cache each Roku's app list")
+	fs.IntVar(&cfg.findRemoteRetries, "find-remote-retries", 2, "Number of times to retry finding the remote when the accessory is identified")
+	fs.BoolVar(&cfg.noIdentifyRemote, "no-find-remote-on-identify", false, "Only log when the accessory is identified, rather than making the remote beep")
+	fs.DurationVar(&cfg.selfTestInterval, "selftest-interval", 0, "Probe each Roku with a device info query this often (0 to disable)")
+	rediscoverInterval := fs.Duration("rediscover-interval", 0, "Search for new Rokus this often (0 to only search at startup)")
+	onReconnect := fs.String("on-reconnect", reconnectWebhook, "Comma-separated actions to take when a Roku becomes reachable again: "+strings.Join(reconnectActions, ", "))
+	featureFirmware := fs.String("feature-min-firmware", "", "Comma-separated minimum firmware versions for features, like volume=9.4; features are "+strings.Join(featureNames, ", "))
+	managed := fs.String("managed-characteristics", defaultManaged, "Comma-separated television characteristics to keep in sync with the Roku: "+strings.Join(managedCharacteristics, ", "))
+	deviceInfoFields := fs.String("device-info-fields", "", "Comma-separated device info fields, like uptime,time_zone, to include in the HTTP API (or \"all\")")
+	fs.BoolVar(&cfg.macHardwareRevision, "mac-hardware-revision", false, "Show each Roku's MAC address as its hardware revision in HomeKit")
+	fs.BoolVar(&cfg.noSSDP, "no-ssdp", false, "Don't search for Rokus with SSDP, for networks without multicast")
+	rokuAddresses := fs.String("roku-address", "", "Comma-separated hostnames or IP addresses of Rokus to set up without searching for them")
+	stableIDs := fs.String("stable-ids", "", "Comma-separated identifier=id pairs: the Roku with that serial number, device ID, MAC address or UDN uses id as its serial number")
+	scanCIDRs := fs.String("scan-cidr", "", "Comma-separated networks, like 192.168.1.0/24, to scan for Rokus in addition to searching with SSDP")
+	fs.DurationVar(&cfg.scanTimeout, "scan-timeout", 2*time.Second, "How long each host gets to answer when scanning for Rokus")
+	discoverInterface := fs.String("discover-interface", "", "Comma-separated network interfaces to search for Rokus on (default all)")
+	simulateNames := fs.String("simulate", "", "Comma-separated names of simulated Rokus to set up instead of searching for real ones")
+	simulateApps := fs.String("simulate-apps", "", "Comma-separated ID:Name apps installed on simulated Rokus")
+	httpAddr := fs.String("http-addr", "", "Address to serve the HTTP API on, e.g. :8080")
+	inventoryFile := fs.String("inventory-file", "", "Write a JSON summary of the Rokus that were set up to this file")
+	tlsCert := fs.String("tls-cert", "", "Certificate file to serve the HTTP API over HTTPS with")
+	tlsKey := fs.String("tls-key", "", "Key file for -tls-cert")
+	apiTokensFile := fs.String("api-tokens-file", "", "JSON file of limited HTTP API tokens and what each may do")
+	apiToken := fs.String("api-token", "", "Bearer token required for HTTP API requests that change anything")
+	devicesConfig := fs.String("devices-config", "", "JSON file with per-device settings, keyed by serial number")
 
-	_ = fs.String("config", "", "Config file")
+	configs := fs.String("config", "", "Comma-separated config files or directories of them, read in order")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: roku-homekit [flags] [command]\n\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s\n", commandsUsage)
+	}
 
 	ff.Parse(fs, os.Args[1:],
 		ff.WithEnvVarPrefix("ROKU"),
-		ff.WithConfigFileFlag("config"),
-		ff.WithConfigFileParser(ff.PlainParser),
 	)
 
+	files, err := configFiles(*configs)
+	if err != nil {
+		log.Fatalf("Invalid -config: %v", err)
+	}
+	if err := parseConfigFiles(fs, files); err != nil {
+		log.Fatalf("Unable to read config: %v", err)
+	}
+
 	if cfg.debug {
 		hclog.Debug.Enable()
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if err := setupLogging(&cfg, *logFile, *logFileSize, *logFileBackups, *logSyslog); err != nil {
+		log.Fatal(err)
+	}
+
+	if *homekitPINFile != "" {
+		pin, err := readSecret(*homekitPINFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.homekitPIN = pin
+	}
 
-	log.Println("Searching for Rokus...")
-	var rokus []*Roku
+	var apiTokens map[string]tokenGrants
+	if *apiTokensFile != "" {
+		var err error
+		apiTokens, err = loadAPITokens(*apiTokensFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
 
-	endpoints, err := roku.Find(5)
+	cfg.storageLayout, err = parseStorageLayout(*storageLayout)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, e := range endpoints {
-		r, err := setupRoku(&cfg, e)
+	cfg.nameTemplate, err = parseNameTemplate(*nameTemplate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.devicesConfig = *devicesConfig
+	cfg.devices, cfg.defaultDevice, err = loadDeviceConfigs(*devicesConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.buttonKeys, err = parseKeys(*buttonKeys)
+	if err != nil {
+		log.Fatalf("Invalid -button-keys: %v", err)
+	}
+
+	cfg.settingsKeys, err = parseKeys(*settingsKeys)
+	if err != nil {
+		log.Fatalf("Invalid -tv-settings-keys: %v", err)
+	}
+
+	cfg.sleepDiscoveryMode, err = parseSleepDiscoveryMode(*sleepDiscoveryMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := checkFallbackInput("screensaver-input", cfg.screensaverInput); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkFallbackInput("active-app-error-input", cfg.errorInput); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.inputTypes, err = parseInputTypes(*inputTypes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.tvCharacteristics, err = parseTVCharacteristics(*tvCharacteristics)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.onReconnect, err = parseReconnectActions(*onReconnect)
+	if err != nil {
+		log.Fatalf("Invalid -on-reconnect: %v", err)
+	}
+
+	cfg.featureFirmware, err = parseFeatureFirmware(*featureFirmware)
+	if err != nil {
+		log.Fatalf("Invalid -feature-min-firmware: %v", err)
+	}
+
+	cfg.managed, err = parseManaged(*managed)
+	if err != nil {
+		log.Fatalf("Invalid -managed-characteristics: %v", err)
+	}
+
+	cfg.deviceInfoFields, err = parseDeviceInfoFields(*deviceInfoFields)
+	if err != nil {
+		log.Fatalf("Invalid -device-info-fields: %v", err)
+	}
+
+	cfg.rokuAddresses, err = parseRokuAddresses(*rokuAddresses)
+	if err != nil {
+		log.Fatalf("Invalid -roku-address: %v", err)
+	}
+
+	cfg.stableIDs, err = parseStableIDs(*stableIDs)
+	if err != nil {
+		log.Fatalf("Invalid -stable-ids: %v", err)
+	}
+
+	cfg.scanCIDRs, err = parseScanCIDRs(*scanCIDRs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setDiscoverInterfaces(*discoverInterface); err != nil {
+		log.Fatal(err)
+	}
+	if !cfg.noSSDP && !haveMulticast() {
+		log.Println("No network interfaces support multicast; not searching for Rokus with SSDP")
+		cfg.noSSDP = true
+	}
+
+	switch *pausedCommands {
+	case "drop":
+	case "queue":
+		cfg.pause.queue = true
+	default:
+		log.Fatalf("invalid -paused-commands %q (must be drop or queue)", *pausedCommands)
+	}
+
+	if *maxInflight > 0 {
+		cfg.inflight = make(chan struct{}, *maxInflight)
+	}
+
+	if cfg.pollInterval <= 0 {
+		log.Fatal("-poll-interval must be positive")
+	}
+	if cfg.launchVerifyDelay < 0 {
+		log.Fatal("-launch-verify-delay must not be negative")
+	}
+
+	// Check -test-key before searching, so that a typo isn't sent to
+	// the Roku and reported as its failure.
+	if *testKey != "" {
+		key, err := canonicalKey(*testKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -test-key: %v\n", err)
+			os.Exit(2)
+		}
+		*testKey = key
+	}
+
+	if *auditLogPath != "" {
+		cfg.audit, err = openAuditLog(*auditLogPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *webhookURL != "" {
+		cfg.webhook = newWebhook(*webhookURL, *webhookDebounce, *webhookRetries)
+	}
+
+	// The roku package uses the default HTTP client.  Bound it by the
+	// longest ECP timeout so that requests abandoned after a timeout
+	// don't linger.
+	if d := cfg.timeouts.max(); d > 0 {
+		http.DefaultClient.Timeout = d
+	}
+	http.DefaultClient.Transport = &checkedTransport{base: http.DefaultTransport, debug: cfg.debug}
+
+	if args := fs.Args(); len(args) > 0 {
+		os.Exit(runCommand(&cfg, fs, args))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		rokusMu sync.Mutex
+		rokus   []*Roku
+	)
+
+	var (
+		found   []discovered
+		pending []*ecpEndpoint
+	)
+	if *simulateNames != "" {
+		apps := defaultSimulatedApps
+		if *simulateApps != "" {
+			apps, err = parseSimulatedApps(*simulateApps)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		found = simulate(*simulateNames, apps)
+	} else {
+		found, pending, err = search(&cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *testKey != "" {
+		os.Exit(sendTestKey(found, *testDevice, *testKey))
+	}
+
+	devices := cfg.limitDevices(found)
+	ports := cfg.assignPorts(devices)
+
+	var setUp []discovered
+	for _, d := range devices {
+		r, err := setupRoku(&cfg, d.endpoint, d.deviceInfo, ports[d.deviceInfo.SerialNumber])
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
 		rokus = append(rokus, r)
+		setUp = append(setUp, d)
+		cfg.fleet.add()
+	}
+
+	warnSharedPINs(rokus)
+	logInventory(rokus, *inventoryFile)
+
+	var api *apiServer
+	if *httpAddr != "" {
+		api = newAPIServer()
+		api.token = *apiToken
+		api.tokens = apiTokens
+		api.pause = &cfg.pause
+		api.tlsCert, api.tlsKey = *tlsCert, *tlsKey
+		for _, r := range rokus {
+			api.add(r)
+		}
+		go api.listenAndServe(*httpAddr)
 	}
 
 	hc.OnTermination(func() {
+		rokusMu.Lock()
+		defer rokusMu.Unlock()
+
 		for _, r := range rokus {
-			<-r.transport.Stop()
+			r.stopTransport()
 		}
 		cancel()
 	})
 
-	for _, r := range rokus {
+	for i, r := range rokus {
+		// Advertising many accessories at once can overwhelm mDNS,
+		// and the Home app then misses some of them.
+		if i > 0 && *transportStagger > 0 {
+			select {
+			case <-time.After(*transportStagger):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
 		log.Printf("Starting transport for %q...", r.deviceInfo.UserDeviceName)
 		r.start(ctx)
 	}
 
+	if *rediscoverInterval > 0 {
+		rd := newRediscoverer(&cfg, *rediscoverInterval, setUp, pending, func(d discovered) error {
+			rokusMu.Lock()
+			n := len(rokus)
+			rokusMu.Unlock()
+
+			if cfg.maxDevices > 0 && n >= cfg.maxDevices {
+				return fmt.Errorf("limit of %d devices reached", cfg.maxDevices)
+			}
+
+			// Rokus found later don't get a port from -port-base, since
+			// the ones already assigned depend on which Rokus were
+			// found at startup.
+			r, err := setupRoku(&cfg, d.endpoint, d.deviceInfo, cfg.device(d.deviceInfo.SerialNumber).Port)
+			if err != nil {
+				return err
+			}
+
+			rokusMu.Lock()
+			defer rokusMu.Unlock()
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rokus = append(rokus, r)
+			cfg.fleet.add()
+			if api != nil {
+				api.add(r)
+			}
+
+			log.Printf("Starting transport for %q...", r.deviceInfo.UserDeviceName)
+			r.start(ctx)
+			return nil
+		})
+		go rd.run(ctx)
+	} else if len(pending) > 0 {
+		log.Printf("%d devices didn't answer with device info; use -rediscover-interval to try them again later", len(pending))
+	}
+
 	<-ctx.Done()
 	log.Printf("Exiting")
 }
 
-func setupRoku(cfg *config, e *roku.Endpoint) (*Roku, error) {
-	deviceInfo, err := e.DeviceInfo()
-	if err != nil {
-		return nil, fmt.Errorf("unable to get device info for %s: %w", e, err)
+// setupRoku builds the HomeKit accessory for a Roku, listening on the
+// given port, or a random one if port is 0.
+func setupRoku(cfg *config, e controller, deviceInfo *roku.DeviceInfo, port int) (*Roku, error) {
+	dc := cfg.device(deviceInfo.SerialNumber)
+	if dc.Name != "" {
+		deviceInfo.UserDeviceName = dc.Name
+	} else {
+		deviceInfo.UserDeviceName = deviceName(cfg, deviceInfo)
 	}
 
 	// Quotation marks cause problems with adding accessories.
@@ -110,106 +599,355 @@ func setupRoku(cfg *config, e *roku.Endpoint) (*Roku, error) {
 	info := accessory.Info{
 		Name:             deviceInfo.UserDeviceName,
 		Manufacturer:     deviceInfo.VendorName,
-		Model:            fmt.Sprintf("%s (%s)", deviceInfo.FriendlyModelName, deviceInfo.ModelNumber),
-		FirmwareRevision: fmt.Sprintf("%s-%s", deviceInfo.SoftwareVersion, deviceInfo.SoftwareBuild),
+		Model:            accessoryModel(deviceInfo),
+		FirmwareRevision: accessoryFirmware(deviceInfo),
 		SerialNumber:     deviceInfo.SerialNumber,
 	}
 
+	// The accessory information name is also used as the mDNS
+	// instance name.  For televisions the Home app shows the
+	// configured name instead, so it can be changed freely.
+	if dc.MDNSName != "" {
+		info.Name = strings.Replace(dc.MDNSName, `"`, "", -1)
+	}
+
+	storagePath, err := deviceStoragePath(cfg, deviceInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine storage path for %q: %w", info.Name, err)
+	}
+	migrateStorage(cfg, deviceInfo, storagePath)
+
+	if err := checkStorage(storagePath); err != nil {
+		if !cfg.resetCorruptStorage {
+			return nil, fmt.Errorf("storage for %q in %s is unusable (use -reset-corrupt-storage to start over and re-pair): %w", info.Name, storagePath, err)
+		}
+
+		backup, rerr := resetStorage(storagePath)
+		if rerr != nil {
+			return nil, fmt.Errorf("unable to reset storage for %q in %s: %w", info.Name, storagePath, rerr)
+		}
+		log.Printf("WARNING: storage for %q in %s was unusable (%v); moved it to %s.  The Roku must be re-added to HomeKit.", info.Name, storagePath, err, backup)
+	}
+
+	state, err := loadDeviceState(storagePath)
+	if err != nil {
+		log.Printf("Unable to load saved state for %q: %v", info.Name, err)
+		state = &deviceState{}
+	}
+
 	r := &Roku{
-		endpoint:   e,
-		deviceInfo: deviceInfo,
-		accessory:  accessory.New(info, accessory.TypeTelevision),
-		tv:         service.NewTelevision(),
+		endpoint:    e,
+		deviceInfo:  deviceInfo,
+		global:      cfg,
+		cfg:         dc,
+		storagePath: storagePath,
+		state:       state,
+		webhook:     cfg.webhook,
+		accessory:   accessory.New(info, accessory.TypeTelevision),
+		tv:          service.NewTelevision(),
+	}
+
+	r.setupReachability()
+	r.features = detectFeatures(cfg, deviceInfo)
+
+	if cfg.macHardwareRevision {
+		if mac := macAddress(deviceInfo); mac != "" {
+			hr := characteristic.NewHardwareRevision()
+			hr.SetValue(mac)
+			r.accessory.Info.AddCharacteristic(hr.Characteristic)
+		}
 	}
 
 	r.accessory.AddService(r.tv.Service)
 
-	apps, err := e.Apps()
+	r.addApp(homeApp)
+
+	r.apps = newAppCache(cfg.appsCacheTTL, e.Apps)
+
+	apps, err := fetchApps(e, cfg.appsAttempts)
 	if err != nil {
-		log.Printf("Error getting apps for %q: %v", info.Name, err)
+		log.Printf("Error getting apps for %q; only power and remote controls will be available: %v", info.Name, err)
 	} else {
-		for _, app := range apps {
+		r.apps.set(apps)
+		for _, app := range withDevChannel(deviceInfo, apps) {
+			if !r.wantInput(app) {
+				continue
+			}
 			r.addApp(app)
 		}
 	}
+	r.saveState()
+
+	var unsupported []string
+	for _, key := range cfg.buttonKeys {
+		if !supportsKey(deviceInfo, key) {
+			unsupported = append(unsupported, key)
+			continue
+		}
+		r.addKeyButton(keyLabel(key), key)
+	}
+	if cfg.allowReboot {
+		r.addButton("Reboot", func() { go r.reboot() })
+	}
+	if dc.Kiosk != nil {
+		r.addKiosk()
+	}
+	if cfg.volumeAsBrightness {
+		if r.features[featureVolume] {
+			r.addVolumeProxy()
+		} else {
+			log.Printf("%q has no volume control; not adding a volume light", info.Name)
+		}
+	}
 
-	r.accessory.OnIdentify(r.identify)
+	if !supportsKey(deviceInfo, roku.FindRemoteKey) {
+		unsupported = append(unsupported, roku.FindRemoteKey)
+	}
+	if len(unsupported) > 0 {
+		log.Printf("%q doesn't support %s; not adding controls for them", info.Name, strings.Join(unsupported, ", "))
+	}
+
+	if cfg.noIdentifyRemote || !supportsKey(deviceInfo, roku.FindRemoteKey) {
+		r.accessory.OnIdentify(func() {
+			log.Printf("Identify requested for %q; not finding the remote", r.deviceInfo.UserDeviceName)
+		})
+	} else {
+		r.accessory.OnIdentify(func() { go r.identify() })
+	}
 
-	r.tv.ConfiguredName.SetValue(r.deviceInfo.UserDeviceName)
-	r.tv.SleepDiscoveryMode.SetValue(characteristic.SleepDiscoveryModeAlwaysDiscoverable)
+	r.setupName()
+	r.configureTelevision()
 
-	r.tv.Active.OnValueRemoteGet(r.getActive)
-	r.tv.Active.OnValueRemoteUpdate(r.setActive)
+	r.tv.Active.OnValueRemoteGet(r.remoteGetActive)
+	if dc.PowerReadOnly {
+		r.tv.Active.OnValueRemoteUpdate(r.rejectActive)
+	} else {
+		r.tv.Active.OnValueRemoteUpdate(r.setActive)
+	}
 
-	r.tv.ActiveIdentifier.OnValueRemoteGet(r.getActiveIdentifier)
+	r.tv.ActiveIdentifier.OnValueRemoteGet(r.remoteGetActiveIdentifier)
 	r.tv.ActiveIdentifier.OnValueRemoteUpdate(r.setActiveIdentifier)
 
 	r.tv.RemoteKey.OnValueRemoteUpdate(r.setRemoteKey)
 
+	pin := cfg.homekitPIN
+	switch {
+	case dc.PIN != "":
+		pin = dc.PIN
+	case cfg.uniquePINs:
+		pin = uniquePIN(cfg.homekitPIN, deviceInfo.SerialNumber)
+		log.Printf("HomeKit PIN for %q is %s", info.Name, pin)
+	}
+
 	hcConfig := hc.Config{
-		Pin:         cfg.homekitPIN,
-		StoragePath: filepath.Join(cfg.storagePath, deviceInfo.SerialNumber),
+		Pin:         pin,
+		StoragePath: storagePath,
+	}
+
+	if port != 0 {
+		if err := checkPortFree(port); err == nil {
+			hcConfig.Port = strconv.Itoa(port)
+		} else if cfg.retryRandomPort {
+			log.Printf("Port %d for %q is unavailable, using a random port: %v", port, info.Name, err)
+		} else {
+			return nil, fmt.Errorf("port %d for %q is unavailable: %w", port, info.Name, err)
+		}
 	}
 
 	t, err := hc.NewIPTransport(hcConfig, r.accessory)
 	if err != nil {
 		return nil, fmt.Errorf("error building IP transport for %q: %w", info.Name, err)
 	}
+	r.hcConfig = hcConfig
 	r.transport = t
 
 	return r, nil
 }
 
 func (r *Roku) start(ctx context.Context) {
-	go r.transport.Start()
+	go r.runTransport()
+	if d := r.global.selfTestInterval; d > 0 {
+		go r.runSelfTest(ctx, d)
+	}
+
+	interval := r.global.pollInterval
+	if r.cfg.pollInterval > 0 {
+		interval = r.cfg.pollInterval
+	}
+
 	go func(ctx context.Context) {
+		// -1 until the first poll, so that we don't report a change
+		// from the characteristics' initial values.
+		last := pollState{active: -1, identifier: -1}
+
+		// Poll right away so HomeKit doesn't see the characteristics'
+		// initial values for a whole interval.
+		r.safePoll(&last)
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(10 * time.Second):
-				r.tv.Active.SetValue(r.getActive())
-				r.tv.ActiveIdentifier.SetValue(r.getActiveIdentifier())
+			case <-time.After(interval):
+				r.safePoll(&last)
 			}
 		}
 	}(ctx)
 }
 
-func (r *Roku) addApp(app *roku.App) {
-	input := service.NewInputSource()
+// safePoll polls the Roku, logging rather than crashing on a panic so
+// that one Roku's bad response doesn't take down the others.  Polling
+// carries on at the next interval.
+func (r *Roku) safePoll(last *pollState) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("ERROR: poll of %q panicked: %v", r.deviceInfo.UserDeviceName, v)
+			hclog.Debug.Printf("Poll panic: %v\n%s", v, debug.Stack())
+		}
+	}()
+
+	r.poll(last)
+}
+
+// pollState is what the previous poll found.
+type pollState struct {
+	active     int
+	identifier int
+
+	// homeSince is when the Roku went back to the home screen from
+	// one of its auto_off apps, or zero if it hasn't.
+	homeSince time.Time
+}
+
+// poll updates HomeKit with the Roku's power state and active app, and
+// reports changes since the last poll to the webhook.  Characteristics
+// are only set when their value has changed, since some HomeKit apps
+// react to every update.
+func (r *Roku) poll(last *pollState) {
+	if r.global.pause.isPaused() {
+		return
+	}
+
+	active := r.getActive()
+	identifier := r.getActiveIdentifier()
+
+	if r.manages(managedActive) && cachedValue(r.tv.Active.Int) != active {
+		r.tv.Active.SetValue(active)
+	}
+	if r.manages(managedActiveIdentifier) && cachedValue(r.tv.ActiveIdentifier.Int) != identifier {
+		r.tv.ActiveIdentifier.SetValue(identifier)
+	}
 
-	input.ConfiguredName.SetValue(app.Name)
-	input.Name.SetValue(app.Name)
-	input.InputSourceType.SetValue(characteristic.InputSourceTypeApplication)
-	input.IsConfigured.SetValue(characteristic.IsConfiguredConfigured)
+	r.checkAutoOff(last, active, identifier)
 
-	id, err := strconv.Atoi(app.ID)
-	if err == nil {
-		input.Identifier.SetValue(id)
+	if last.active != -1 && active != last.active {
+		r.notifyChange("power", powerString(last.active), powerString(active))
+	}
+	if last.identifier != -1 && identifier != last.identifier {
+		r.notifyAppChange(last.identifier, identifier)
 	}
+	last.active, last.identifier = active, identifier
+}
+
+// cachedValue returns c's last known value.  Unlike GetValue, it
+// doesn't call the function registered with OnValueRemoteGet, which
+// would ask the Roku, or recurse when called from that function.
+func cachedValue(c *characteristic.Int) int {
+	v, _ := c.Value.(int)
+	return v
+}
+
+func (r *Roku) notifyChange(typ, old, new string) {
+	r.notify(stateChange{Type: typ, Old: old, New: new})
+}
+
+// notifyAppChange reports a change of the active app, given the
+// identifiers of the old and new apps' inputs, with the apps' IDs and
+// names.
+func (r *Roku) notifyAppChange(old, new int) {
+	oldID, newID := r.inputs[old], r.inputs[new]
+	r.notify(stateChange{
+		Type:    "app",
+		Old:     oldID,
+		New:     newID,
+		OldName: r.appNames[oldID],
+		NewName: r.appNames[newID],
+	})
+}
+
+func (r *Roku) notify(c stateChange) {
+	c.Serial = r.deviceInfo.SerialNumber
+	c.Name = r.deviceInfo.UserDeviceName
+	c.Timestamp = time.Now()
+	r.webhook.notify(c)
+}
 
-	r.accessory.AddService(input.Service)
-	r.tv.AddLinkedService(input.Service)
+func powerString(active int) string {
+	if active == characteristic.ActiveActive {
+		return "on"
+	}
+	return "off"
 }
 
+// identify makes the Roku's remote beep, retrying a few times since a
+// lost remote is exactly when it matters that this works.
 func (r *Roku) identify() {
-	if err := r.endpoint.FindRemote(); err != nil {
-		log.Printf("Unable to find remote for %q: %v", r.deviceInfo.UserDeviceName, err)
+	var err error
+	for attempt := 0; attempt <= r.global.findRemoteRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+
+		err = r.endpoint.FindRemote()
+		r.audit(auditHomeKit, "find remote", err)
+		if err == nil {
+			return
+		}
+
+		log.Printf("Unable to find remote for %q (attempt %d of %d): %v",
+			r.deviceInfo.UserDeviceName, attempt+1, r.global.findRemoteRetries+1, err)
 	}
+
+	log.Printf("ERROR: giving up finding the remote for %q: %v", r.deviceInfo.UserDeviceName, err)
+	r.notifyChange("find_remote", "", "failed")
 }
 
 func (r *Roku) getActive() int {
+	if r.global.pause.isPaused() {
+		// Not the characteristic's value, which a power change from
+		// HomeKit has already set even if it's being held back.
+		return powerActive(r.currentDeviceInfo())
+	}
+
 	var (
 		deviceInfo *roku.DeviceInfo
 		err        error
 	)
 
 	deviceInfo, err = r.endpoint.DeviceInfo()
+	r.reach.record(err)
 	if err != nil {
 		log.Printf("unable to get device info for %s: %v", r.deviceInfo.UserDeviceName, err)
 		deviceInfo = r.deviceInfo // fallback to last known
+	} else {
+		r.latestMu.Lock()
+		prev := r.latest
+		r.latest = deviceInfo
+		r.latestMu.Unlock()
+
+		// The accessory was set up from fresh device info, so there's
+		// nothing to compare the first poll to.
+		if prev != nil && infoChanged(prev, deviceInfo) {
+			r.updateInfo(deviceInfo)
+		}
 	}
 
+	return powerActive(deviceInfo)
+}
+
+// powerActive returns the HomeKit power state for the Roku's device
+// info.
+func powerActive(deviceInfo *roku.DeviceInfo) int {
 	if deviceInfo.PowerMode == "PowerOn" {
 		return characteristic.ActiveActive
 	} else {
@@ -217,41 +955,281 @@ func (r *Roku) getActive() int {
 	}
 }
 
+// network returns the type (wifi or ethernet) and name of the network
+// the Roku was on when last polled.
+func (r *Roku) network() (typ, name string) {
+	info := r.currentDeviceInfo()
+	return info.NetworkType, info.NetworkName
+}
+
 func (r *Roku) setActive(active int) {
-	key := "PowerOn" // roku package doesn't have this, oddly
-	if active == characteristic.ActiveInactive {
-		key = roku.PowerOffKey
+	revert := func() { r.tv.Active.SetValue(r.getActive()) }
+	if r.whilePausedOrRevert("power "+powerString(active), func() { r.setActive(active) }, revert) {
+		return
+	}
+
+	if r.holdPower(active) {
+		return
 	}
 
-	if err := r.endpoint.Keypress(key); err != nil {
+	key := r.powerKey(active)
+
+	// Only launch the power-on app if the Roku wasn't already on, so
+	// we don't switch away from whatever someone is watching.
+	launchApp := r.cfg.PowerOnApp != "" &&
+		active == characteristic.ActiveActive &&
+		r.getActive() != characteristic.ActiveActive
+
+	err := r.endpoint.Keypress(key)
+	r.audit(auditHomeKit, "power "+powerString(active), err)
+	if err != nil {
 		log.Printf("Keypress %q on %q: %v", key, r.deviceInfo.UserDeviceName, err)
+		return
+	}
+
+	go r.confirmPower(active)
+
+	if launchApp {
+		go r.launchPowerOnApp()
+	}
+}
+
+// powerKey returns the key that turns the Roku on or off.
+func (r *Roku) powerKey(active int) string {
+	if active == characteristic.ActiveInactive {
+		if r.cfg.PowerOffKey != "" {
+			return r.cfg.PowerOffKey
+		}
+		return roku.PowerOffKey
 	}
+
+	if r.cfg.PowerOnKey != "" {
+		return r.cfg.PowerOnKey
+	}
+	return powerOnKey
+}
+
+// rejectActive ignores power changes from HomeKit for Rokus whose
+// power state is read-only, and puts the reported state back.
+func (r *Roku) rejectActive(active int) {
+	log.Printf("Ignoring power %s request for %q: power is read-only", powerString(active), r.deviceInfo.UserDeviceName)
+	r.audit(auditHomeKit, "power "+powerString(active), errors.New("power is read-only"))
+	go r.tv.Active.SetValue(r.getActive())
+}
+
+// confirmPower polls the Roku a few times after a power command, so
+// that HomeKit learns about the change sooner than the next regular
+// poll.
+func (r *Roku) confirmPower(want int) {
+	for i := 0; i < r.global.powerConfirmPolls; i++ {
+		time.Sleep(r.global.powerConfirmInterval)
+
+		active := r.getActive()
+		r.tv.Active.SetValue(active)
+		if active == want {
+			return
+		}
+	}
+}
+
+// launchPowerOnApp waits for the Roku to finish powering on and then
+// launches the configured power-on app.
+func (r *Roku) launchPowerOnApp() {
+	const (
+		wait     = 30 * time.Second
+		interval = time.Second
+	)
+
+	for deadline := time.Now().Add(wait); time.Now().Before(deadline); {
+		time.Sleep(interval)
+
+		if r.getActive() != characteristic.ActiveActive {
+			continue
+		}
+
+		id := r.cfg.PowerOnApp
+		if id == homeApp.ID {
+			return
+		}
+
+		if apps, err := r.apps.get(); err == nil {
+			if _, err := resolveApp(apps, id); err != nil {
+				if isChannelID(id) {
+					err = r.notInstalled(id, auditHomeKit, "open store for power-on app")
+				}
+				log.Printf("Couldn't launch power-on app on %q: %v", r.deviceInfo.UserDeviceName, err)
+				return
+			}
+		}
+
+		// Apps can fail to launch while the Roku is still waking up, so
+		// try a few times, unless it's been turned off in the meantime.
+		err := r.tryPowerOnApp(id)
+		retries := r.global.powerOnAppRetries
+		for attempt := 1; err != nil && attempt <= retries; attempt++ {
+			log.Printf("Power-on app ID %s on %q (attempt %d of %d): %v", id, r.deviceInfo.UserDeviceName, attempt, retries+1, err)
+			time.Sleep(r.global.powerOnAppRetryDelay)
+			if r.getActive() != characteristic.ActiveActive {
+				log.Printf("%q was turned off; not retrying power-on app ID %s", r.deviceInfo.UserDeviceName, id)
+				return
+			}
+			err = r.tryPowerOnApp(id)
+		}
+
+		if err != nil {
+			log.Printf("ERROR: giving up launching power-on app ID %s on %q: %v", id, r.deviceInfo.UserDeviceName, err)
+			r.notifyChange("power_on_app", "", "failed")
+		}
+		return
+	}
+
+	log.Printf("%q didn't power on within %v; not launching app ID %s", r.deviceInfo.UserDeviceName, wait, r.cfg.PowerOnApp)
+}
+
+// tryPowerOnApp launches the power-on app and checks that it came up.
+func (r *Roku) tryPowerOnApp(id string) error {
+	r.launchMu.Lock()
+	err := r.endpoint.LaunchApp(id, nil)
+	r.launchMu.Unlock()
+	r.audit(auditHomeKit, "launch "+id, err)
+	if err != nil {
+		return fmt.Errorf("couldn't launch: %w", err)
+	}
+	r.countLaunch(id)
+
+	active, err := r.waitForActiveApp(id)
+	if err != nil {
+		return fmt.Errorf("couldn't check the active app: %w", err)
+	}
+	if active.ID != id {
+		return fmt.Errorf("didn't come up; %q is active", active.Name)
+	}
+	return nil
+}
+
+// remoteGetActive and remoteGetActiveIdentifier answer HomeKit's reads.
+// While the Roku is unreachable they answer with the last known values
+// right away, rather than waiting for an ECP request to time out while
+// the Home app spins.  Polls keep making live requests, so these go
+// back to asking the Roku once it's reachable again.
+func (r *Roku) remoteGetActive() int {
+	if !r.reach.isReachable() {
+		return cachedValue(r.tv.Active.Int)
+	}
+	return r.getActive()
+}
+
+func (r *Roku) remoteGetActiveIdentifier() int {
+	if !r.reach.isReachable() {
+		return cachedValue(r.tv.ActiveIdentifier.Int)
+	}
+	return r.getActiveIdentifier()
 }
 
 func (r *Roku) getActiveIdentifier() int {
+	id := r.activeIdentifier()
+	atomic.StoreInt32(&r.lastIdentifier, int32(id))
+	return id
+}
+
+func (r *Roku) activeIdentifier() int {
+	if r.global.pause.isPaused() {
+		return r.fallbackIdentifier(inputPrevious)
+	}
+
 	app, err := r.endpoint.ActiveApp()
 	if err != nil {
 		log.Printf("Couldn't get active app for %q: %v", r.deviceInfo.UserDeviceName, err)
-		return 0
+		return r.fallbackIdentifier(r.global.errorInput)
 	}
 
+	// The home screen has no app ID.
 	if app.ID == "" {
-		return 0
+		return homeIdentifier
 	}
 
-	id, err := strconv.Atoi(app.ID)
-	if err != nil {
-		log.Printf("Couldn't convert %q to an int: %v", app.ID, err)
-		return 0
+	if app.Type == screensaverAppType {
+		return r.fallbackIdentifier(r.global.screensaverInput)
+	}
+
+	// Apps without an input, like ones left out by -input-types or
+	// installed since the inputs were set up, have no identifier
+	// HomeKit knows about.
+	id, ok := r.identifiers[app.ID]
+	if !ok || !validIdentifier(id) {
+		log.Printf("Active app %q (ID %s) on %q has no input", app.Name, app.ID, r.deviceInfo.UserDeviceName)
+		return homeIdentifier
 	}
 
 	return id
 }
 
+// fallbackIdentifier returns the identifier to report, according to
+// the given -screensaver-input or -active-app-error-input setting, when
+// the active app isn't known.
+func (r *Roku) fallbackIdentifier(setting string) int {
+	if setting == inputPrevious {
+		if id := atomic.LoadInt32(&r.lastIdentifier); id != 0 {
+			return int(id)
+		}
+	}
+	return homeIdentifier
+}
+
 func (r *Roku) setActiveIdentifier(id int) {
-	if err := r.endpoint.LaunchApp(strconv.Itoa(id), nil); err != nil {
-		log.Printf("Couldn't launch app ID %d: %v", id, err)
+	revert := func() { r.tv.ActiveIdentifier.SetValue(r.getActiveIdentifier()) }
+	if r.whilePausedOrRevert("input change", func() { r.setActiveIdentifier(id) }, revert) {
+		return
+	}
+
+	if id == homeIdentifier {
+		r.launchMu.Lock()
+		err := r.endpoint.Keypress(roku.HomeKey)
+		r.launchMu.Unlock()
+		r.audit(auditHomeKit, "input home", err)
+		if err != nil {
+			log.Printf("Keypress %q on %q: %v", roku.HomeKey, r.deviceInfo.UserDeviceName, err)
+		}
+		return
+	}
+
+	appID, ok := r.inputs[id]
+	if !ok {
+		// Probably an automation or scene set up before the app was
+		// uninstalled.
+		fallback := r.global.unknownInput
+		log.Printf("WARNING: input %d on %q no longer exists", id, r.deviceInfo.UserDeviceName)
+		r.audit(auditHomeKit, "input "+strconv.Itoa(id), errors.New("no such input"))
+
+		switch fallback {
+		case "":
+			go r.tv.ActiveIdentifier.SetValue(r.getActiveIdentifier())
+			return
+		case inputHome:
+			log.Printf("Going to the home screen on %q instead", r.deviceInfo.UserDeviceName)
+			r.setActiveIdentifier(homeIdentifier)
+			return
+		}
+
+		_, ok = r.identifiers[fallback]
+		if !ok {
+			log.Printf("Fallback input %s isn't an input on %q either", fallback, r.deviceInfo.UserDeviceName)
+			go r.tv.ActiveIdentifier.SetValue(r.getActiveIdentifier())
+			return
+		}
+		log.Printf("Launching app ID %s on %q instead", fallback, r.deviceInfo.UserDeviceName)
+		appID = fallback
+	}
+
+	r.launchMu.Lock()
+	err := r.endpoint.LaunchApp(appID, nil)
+	r.launchMu.Unlock()
+	r.audit(auditHomeKit, "input "+appID, err)
+	if err != nil {
+		log.Printf("Couldn't launch app ID %s: %v", appID, err)
+		return
 	}
+	r.countLaunch(appID)
 }
 
 var keymap = map[int]string{
@@ -271,8 +1249,63 @@ var keymap = map[int]string{
 }
 
 func (r *Roku) setRemoteKey(k int) {
+	if r.whilePaused("remote key", func() { r.setRemoteKey(k) }) {
+		return
+	}
+
+	if cachedValue(r.tv.Active.Int) == characteristic.ActiveInactive {
+		switch r.cfg.RemoteWhenOff {
+		case remoteWhenOffIgnore:
+			log.Printf("Ignoring remote key %d for %q: it's off", k, r.deviceInfo.UserDeviceName)
+			return
+
+		case remoteWhenOffWake:
+			// A held-back wake would turn the Roku on later without
+			// the key, so drop both instead.
+			if !r.powerReady() {
+				log.Printf("Not sending remote key %d for %q: it's off and power was changed too recently to turn it on", k, r.deviceInfo.UserDeviceName)
+				return
+			}
+
+			key := r.powerKey(characteristic.ActiveActive)
+			err := r.endpoint.Keypress(key)
+			r.audit(auditHomeKit, "power on for remote key", err)
+			if err != nil {
+				log.Printf("Keypress %q on %q: %v", key, r.deviceInfo.UserDeviceName, err)
+				return
+			}
+			r.tv.Active.SetValue(characteristic.ActiveActive)
+
+			go func() {
+				time.Sleep(r.cfg.remoteWakeDelay)
+				r.sendRemoteKey(k)
+			}()
+			return
+		}
+	}
+
+	r.sendRemoteKey(k)
+}
+
+// sendRemoteKey sends the Roku key, or macro, for a key on HomeKit's
+// remote.
+func (r *Roku) sendRemoteKey(k int) {
+	if keys := r.cfg.remoteMacros[k]; len(keys) > 0 {
+		// Macros take a while to send, so don't hold up HomeKit.
+		go func() {
+			err := r.sendKeys(keys)
+			r.audit(auditHomeKit, "macro "+strings.Join(keys, ","), err)
+			if err != nil {
+				log.Printf("Remote macro on %q: %v", r.deviceInfo.UserDeviceName, err)
+			}
+		}()
+		return
+	}
+
 	if key := keymap[k]; key != "" {
-		if err := r.endpoint.Keypress(key); err != nil {
+		err := r.endpoint.Keypress(key)
+		r.audit(auditHomeKit, "key "+key, err)
+		if err != nil {
 			log.Printf("Keypress %q on %q: %v", key, r.deviceInfo.UserDeviceName, err)
 		}
 	}
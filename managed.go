@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Television characteristics whose values roku-homekit can keep in
+// sync with the Roku.
+const (
+	managedActive           = "active"
+	managedActiveIdentifier = "active-identifier"
+	managedConfiguredName   = "configured-name"
+)
+
+var managedCharacteristics = []string{managedActive, managedActiveIdentifier, managedConfiguredName}
+
+// defaultManaged leaves the TV's name alone once it's been renamed in
+// the Home app.
+const defaultManaged = managedActive + "," + managedActiveIdentifier
+
+// parseManaged parses a comma-separated list of characteristics to
+// manage.
+func parseManaged(s string) (map[string]bool, error) {
+	managed := map[string]bool{}
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+
+		i := sort.SearchStrings(sortedManaged, c)
+		if i == len(sortedManaged) || sortedManaged[i] != c {
+			return nil, fmt.Errorf("unknown characteristic %q; must be one of %s", c, strings.Join(managedCharacteristics, ", "))
+		}
+		managed[c] = true
+	}
+	return managed, nil
+}
+
+var sortedManaged = func() []string {
+	s := append([]string(nil), managedCharacteristics...)
+	sort.Strings(s)
+	return s
+}()
+
+// manages reports whether roku-homekit keeps the characteristic in sync
+// with the Roku, rather than leaving it to the Home app.
+func (r *Roku) manages(c string) bool {
+	return r.global.managed[c]
+}
+
+// setupName sets the TV's name in the Home app.  Unless configured-name
+// is managed, a name given in the Home app is kept and saved, and the
+// Roku's own name is only used until then.
+func (r *Roku) setupName() {
+	name := r.deviceInfo.UserDeviceName
+	if saved := r.savedTVName(); saved != "" && !r.manages(managedConfiguredName) {
+		name = saved
+	}
+	r.tv.ConfiguredName.SetValue(name)
+
+	r.tv.ConfiguredName.OnValueRemoteUpdate(func(name string) {
+		if r.manages(managedConfiguredName) {
+			log.Printf("%q was renamed %q in HomeKit, but its name is managed; resetting it to the Roku's name", r.deviceInfo.UserDeviceName, name)
+			go r.tv.ConfiguredName.SetValue(r.rokuTVName())
+			return
+		}
+
+		log.Printf("%q was renamed %q in HomeKit", r.deviceInfo.UserDeviceName, name)
+		r.saveTVName(name)
+	})
+}
+
+// rokuTVName returns the name the Roku last reported for itself, or
+// the one set in the devices config.
+func (r *Roku) rokuTVName() string {
+	r.latestMu.Lock()
+	latest := r.latest
+	r.latestMu.Unlock()
+
+	// The name the accessory was set up with has already been through
+	// the name template.
+	if r.cfg.Name != "" || latest == nil {
+		return r.deviceInfo.UserDeviceName
+	}
+	return strings.Replace(deviceName(r.global, latest), `"`, "", -1)
+}
+
+func (r *Roku) savedTVName() string {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if r.state.Name != nil {
+		return *r.state.Name
+	}
+	return ""
+}
+
+func (r *Roku) saveTVName(name string) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	r.state.Name = &name
+	r.saveStateLocked()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// handleMetrics serves metrics in the Prometheus text format.
+func (s *apiServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	rokus := s.list()
+
+	reachable := 0
+	for _, r := range rokus {
+		if r.reach.isReachable() {
+			reachable++
+		}
+	}
+
+	writeMetricHeader(w, "roku_devices", "gauge", "Number of Rokus that were set up.")
+	fmt.Fprintf(w, "roku_devices %d\n", len(rokus))
+
+	writeMetricHeader(w, "roku_devices_reachable", "gauge", "Number of Rokus answering ECP requests.")
+	fmt.Fprintf(w, "roku_devices_reachable %d\n", reachable)
+
+	writeMetricHeader(w, "roku_reachable", "gauge", "Whether the Roku is answering ECP requests.")
+	for _, r := range rokus {
+		fmt.Fprintf(w, "roku_reachable{%s} %d\n", r.metricLabels(), boolMetric(r.reach.isReachable()))
+	}
+
+	writeMetricHeader(w, "roku_network_info", "gauge", "The network the Roku is on, in the labels.")
+	for _, r := range rokus {
+		typ, name := r.network()
+		fmt.Fprintf(w, "roku_network_info{%s,type=%s,network=%s} 1\n", r.metricLabels(), labelValue(typ), labelValue(name))
+	}
+
+	writeMetricHeader(w, "roku_app_launches_total", "counter", "Number of times each app was launched through roku-homekit.")
+	for _, r := range rokus {
+		for _, lc := range r.launches.all() {
+			fmt.Fprintf(w, "roku_app_launches_total{%s,app_id=%s,app=%s} %d\n", r.metricLabels(), labelValue(lc.id), labelValue(lc.name), lc.n)
+		}
+	}
+
+	writeMetricHeader(w, "roku_selftest_success", "gauge", "Whether the most recent self-test probe succeeded.")
+	for _, r := range rokus {
+		if at, _, err := r.selfTest.last(); !at.IsZero() {
+			fmt.Fprintf(w, "roku_selftest_success{%s} %d\n", r.metricLabels(), boolMetric(err == nil))
+		}
+	}
+
+	writeMetricHeader(w, "roku_selftest_latency_seconds", "gauge", "Latency of the most recent self-test probe.")
+	for _, r := range rokus {
+		if at, latency, _ := r.selfTest.last(); !at.IsZero() {
+			fmt.Fprintf(w, "roku_selftest_latency_seconds{%s} %g\n", r.metricLabels(), latency.Seconds())
+		}
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (r *Roku) metricLabels() string {
+	return fmt.Sprintf("serial=%s,name=%s", labelValue(r.deviceInfo.SerialNumber), labelValue(r.deviceInfo.UserDeviceName))
+}
+
+// labelEscaper escapes label values for the Prometheus text format,
+// which only escapes backslashes, double quotes and newlines.  Go's %q
+// escapes more, like non-ASCII characters in a Roku's name, and
+// Prometheus would keep those escapes literally.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns s quoted as a Prometheus label value.
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/picatz/roku"
+)
+
+// defaultNameTemplate names each accessory after the Roku's own name.
+const defaultNameTemplate = "{{.UserDeviceName}}"
+
+func parseNameTemplate(s string) (*template.Template, error) {
+	t, err := template.New("name-template").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// deviceName renders the name template for a Roku.  If it fails or
+// renders an empty name, the Roku's own name is used.
+func deviceName(cfg *config, deviceInfo *roku.DeviceInfo) string {
+	var buf bytes.Buffer
+	if err := cfg.nameTemplate.Execute(&buf, deviceInfo); err != nil {
+		log.Printf("Unable to render name template for %q: %v", deviceInfo.UserDeviceName, err)
+		return deviceInfo.UserDeviceName
+	}
+
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return deviceInfo.UserDeviceName
+	}
+	return name
+}
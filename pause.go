@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// maxQueuedCommands bounds how many commands are kept while paused.
+const maxQueuedCommands = 100
+
+// pauser pauses polling and commands for all Rokus, for maintenance
+// such as firmware updates.  While paused, HomeKit is given the last
+// known state, and commands from HomeKit are either dropped or queued
+// until polling resumes.
+type pauser struct {
+	mu     sync.Mutex
+	paused bool
+	queue  bool
+	queued []func()
+}
+
+func (p *pauser) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *pauser) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		log.Printf("Pausing polling and commands")
+	}
+	p.paused = true
+}
+
+// resume unpauses and then runs any queued commands, in order.
+func (p *pauser) resume() {
+	p.mu.Lock()
+	if p.paused {
+		log.Printf("Resuming polling and commands (%d queued)", len(p.queued))
+	}
+	p.paused = false
+	queued := p.queued
+	p.queued = nil
+	p.mu.Unlock()
+
+	go func() {
+		for _, fn := range queued {
+			fn()
+		}
+	}()
+}
+
+// status returns whether things are paused and how many commands are
+// queued.
+func (p *pauser) status() (paused bool, queued int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, len(p.queued)
+}
+
+// whilePaused reports whether commands are paused, queueing retry to
+// be run on resume if they're being queued.  Commands call it first
+// thing, passing a function that calls them again.
+func (r *Roku) whilePaused(command string, retry func()) bool {
+	return r.whilePausedOrRevert(command, retry, nil)
+}
+
+// whilePausedOrRevert is like whilePaused, but calls revert if
+// the command is dropped, so that a characteristic HomeKit changed can
+// be put back.
+func (r *Roku) whilePausedOrRevert(command string, retry, revert func()) bool {
+	p := &r.global.pause
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return false
+	}
+
+	switch {
+	case !p.queue:
+		log.Printf("Ignoring %s for %q while paused", command, r.deviceInfo.UserDeviceName)
+	case len(p.queued) >= maxQueuedCommands:
+		log.Printf("Ignoring %s for %q while paused: too many commands queued", command, r.deviceInfo.UserDeviceName)
+	default:
+		log.Printf("Queueing %s for %q until resumed", command, r.deviceInfo.UserDeviceName)
+		p.queued = append(p.queued, retry)
+		return true
+	}
+
+	if revert != nil {
+		go revert()
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// pingCount is how many DeviceInfo requests ping makes to each Roku.
+	pingCount = 5
+
+	// pingInterval is the pause between them.
+	pingInterval = 200 * time.Millisecond
+)
+
+// pingResult is the round-trip time of a Roku's DeviceInfo requests.
+type pingResult struct {
+	min, max, total time.Duration
+	ok, failed      int
+	err             error // the last failure
+}
+
+func (p *pingResult) avg() time.Duration {
+	if p.ok == 0 {
+		return 0
+	}
+	return p.total / time.Duration(p.ok)
+}
+
+// ping times pingCount DeviceInfo requests to the Roku.
+func ping(c controller) pingResult {
+	var p pingResult
+	for i := 0; i < pingCount; i++ {
+		if i > 0 {
+			time.Sleep(pingInterval)
+		}
+
+		start := time.Now()
+		_, err := c.DeviceInfo()
+		d := time.Since(start)
+		if err != nil {
+			p.failed++
+			p.err = err
+			continue
+		}
+
+		if p.ok == 0 || d < p.min {
+			p.min = d
+		}
+		if d > p.max {
+			p.max = d
+		}
+		p.total += d
+		p.ok++
+	}
+	return p
+}
+
+// runPing reports the DeviceInfo latency of one Roku, or of every Roku
+// found if no serial number is given.
+func runPing(cfg *config, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: roku-homekit ping [serial]")
+		return 2
+	}
+
+	found, err := discover(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(args) == 1 {
+		d, err := selectDevice(found, args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		found = []discovered{*d}
+	}
+
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSERIAL\tHOST\tMIN\tAVG\tMAX\tFAILED")
+
+	var errs []string
+	for _, d := range found {
+		p := ping(d.endpoint)
+		if p.ok == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t-\t-\t-\t%d/%d\n",
+				d.deviceInfo.UserDeviceName, d.deviceInfo.SerialNumber, d.endpoint.host(), p.failed, pingCount)
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d/%d\n",
+				d.deviceInfo.UserDeviceName, d.deviceInfo.SerialNumber, d.endpoint.host(),
+				ms(p.min), ms(p.avg()), ms(p.max), p.failed, pingCount)
+		}
+
+		if p.err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", d.deviceInfo.UserDeviceName, p.err))
+		}
+	}
+	tw.Flush()
+
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+	"github.com/picatz/roku"
+)
+
+// fakeRoku is a controller that reports each of powerModes and
+// activeApps in turn, repeating the last one once they run out.  It
+// counts the device info and active app requests it receives.
+type fakeRoku struct {
+	powerModes []string
+	activeApps []string
+
+	deviceInfoCalls int
+	activeAppCalls  int
+}
+
+func nextValue(vals *[]string) string {
+	v := (*vals)[0]
+	if len(*vals) > 1 {
+		*vals = (*vals)[1:]
+	}
+	return v
+}
+
+func (f *fakeRoku) DeviceInfo() (*roku.DeviceInfo, error) {
+	f.deviceInfoCalls++
+	return &roku.DeviceInfo{PowerMode: nextValue(&f.powerModes)}, nil
+}
+
+func (f *fakeRoku) ActiveApp() (*roku.App, error) {
+	f.activeAppCalls++
+	return &roku.App{ID: nextValue(&f.activeApps)}, nil
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+func (f *fakeRoku) Apps() (roku.Apps, error)                  { return nil, errNotImplemented }
+func (f *fakeRoku) Icon(string) ([]byte, error)               { return nil, errNotImplemented }
+func (f *fakeRoku) Keypress(string) error                     { return errNotImplemented }
+func (f *fakeRoku) FindRemote() error                         { return errNotImplemented }
+func (f *fakeRoku) LaunchApp(string, map[string]string) error { return errNotImplemented }
+func (f *fakeRoku) Input(map[string]string, string) error     { return errNotImplemented }
+func (f *fakeRoku) InstallApp(string) error                   { return errNotImplemented }
+func (f *fakeRoku) String() string                            { return "http://192.0.2.1:8060/" }
+func (f *fakeRoku) host() string                              { return "192.0.2.1" }
+
+func TestPollRequestsOncePerPoll(t *testing.T) {
+	f := &fakeRoku{
+		powerModes: []string{"PowerOn", "PowerOn", "PowerOn", "DisplayOff", "DisplayOff"},
+		activeApps: []string{"12", "12", "13", "13", "13"},
+	}
+
+	r := &Roku{
+		endpoint:    f,
+		deviceInfo:  &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:      &config{managed: map[string]bool{managedActive: true, managedActiveIdentifier: true}},
+		cfg:         &deviceConfig{},
+		identifiers: map[string]int{"12": 12, "13": 13},
+		tv:          service.NewTelevision(),
+		reach:       newReachability(1, 1, 0, nil),
+	}
+	r.tv.Active.OnValueRemoteGet(r.remoteGetActive)
+	r.tv.ActiveIdentifier.OnValueRemoteGet(r.remoteGetActiveIdentifier)
+
+	last := pollState{active: -1, identifier: -1}
+	for i := 0; i < 5; i++ {
+		deviceInfoCalls, activeAppCalls := f.deviceInfoCalls, f.activeAppCalls
+		r.poll(&last)
+
+		if n := f.deviceInfoCalls - deviceInfoCalls; n != 1 {
+			t.Errorf("poll %d: %d device info requests, want 1", i+1, n)
+		}
+		if n := f.activeAppCalls - activeAppCalls; n != 1 {
+			t.Errorf("poll %d: %d active app requests, want 1", i+1, n)
+		}
+	}
+
+	if got := cachedValue(r.tv.Active.Int); got != characteristic.ActiveInactive {
+		t.Errorf("Active = %d, want %d", got, characteristic.ActiveInactive)
+	}
+	if got := cachedValue(r.tv.ActiveIdentifier.Int); got != 13 {
+		t.Errorf("ActiveIdentifier = %d, want 13", got)
+	}
+}
+
+// alwaysNotifying returns an Int characteristic that, unlike the
+// television's, calls its OnValueUpdate functions on every SetValue,
+// even of the value it already has, so tests can see redundant sets.
+func alwaysNotifying() *characteristic.Int {
+	c := characteristic.NewProgrammableSwitchEvent().Int
+	c.Format = characteristic.FormatInt32
+	c.MinValue, c.MaxValue = nil, nil
+	return c
+}
+
+// countSets returns a pointer to the number of times c's value is set.
+func countSets(c *characteristic.Characteristic) *int {
+	n := new(int)
+	c.OnValueUpdate(func(*characteristic.Characteristic, interface{}, interface{}) {
+		*n++
+	})
+	return n
+}
+
+func TestPollSetsOnlyOnChange(t *testing.T) {
+	f := &fakeRoku{
+		powerModes: []string{"PowerOn", "PowerOn", "PowerOn", "DisplayOff", "DisplayOff"},
+		activeApps: []string{"12", "12", "13", "13", "13"},
+	}
+
+	r := &Roku{
+		endpoint:    f,
+		deviceInfo:  &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:      &config{managed: map[string]bool{managedActive: true, managedActiveIdentifier: true}},
+		cfg:         &deviceConfig{},
+		identifiers: map[string]int{"12": 12, "13": 13},
+		tv:          service.NewTelevision(),
+		reach:       newReachability(1, 1, 0, nil),
+	}
+	r.tv.Active = &characteristic.Active{Int: alwaysNotifying()}
+	r.tv.ActiveIdentifier = &characteristic.ActiveIdentifier{Int: alwaysNotifying()}
+	r.tv.Active.OnValueRemoteGet(r.remoteGetActive)
+	r.tv.ActiveIdentifier.OnValueRemoteGet(r.remoteGetActiveIdentifier)
+
+	activeSets := countSets(r.tv.Active.Characteristic)
+	identifierSets := countSets(r.tv.ActiveIdentifier.Characteristic)
+
+	last := pollState{active: -1, identifier: -1}
+	want := []struct {
+		active, identifier int
+	}{
+		{1, 1}, // on, 12
+		{1, 1},
+		{1, 2}, // 13
+		{2, 2}, // off
+		{2, 2},
+	}
+	for i, w := range want {
+		r.poll(&last)
+
+		if *activeSets != w.active {
+			t.Errorf("poll %d: Active set %d times, want %d", i+1, *activeSets, w.active)
+		}
+		if *identifierSets != w.identifier {
+			t.Errorf("poll %d: ActiveIdentifier set %d times, want %d", i+1, *identifierSets, w.identifier)
+		}
+	}
+}
+
+func TestDroppedPowerChangeIsReverted(t *testing.T) {
+	r := &Roku{
+		endpoint:   &fakeRoku{},
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		latest:     &roku.DeviceInfo{PowerMode: "PowerOff"},
+		global:     &config{},
+		cfg:        &deviceConfig{},
+		tv:         service.NewTelevision(),
+	}
+	r.global.pause.pause()
+
+	// HomeKit sets the characteristic before asking for the change.
+	r.tv.Active.SetValue(characteristic.ActiveActive)
+	sets := make(chan int, 1)
+	r.tv.Active.OnValueUpdate(func(_ *characteristic.Characteristic, v, _ interface{}) {
+		sets <- v.(int)
+	})
+
+	r.setActive(characteristic.ActiveActive)
+
+	select {
+	case v := <-sets:
+		if v != characteristic.ActiveInactive {
+			t.Errorf("Active set to %d, want %d", v, characteristic.ActiveInactive)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Active wasn't put back after the power change was dropped")
+	}
+}
+
+func TestManagedNameIsReset(t *testing.T) {
+	r := &Roku{
+		endpoint:   &fakeRoku{},
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Living Room"},
+		latest:     &roku.DeviceInfo{UserDeviceName: "Den"},
+		global:     &config{managed: map[string]bool{managedConfiguredName: true}},
+		cfg:        &deviceConfig{},
+		state:      &deviceState{},
+		tv:         service.NewTelevision(),
+	}
+	var err error
+	if r.global.nameTemplate, err = parseNameTemplate(defaultNameTemplate); err != nil {
+		t.Fatal(err)
+	}
+	r.setupName()
+
+	sets := make(chan string, 1)
+	r.tv.ConfiguredName.OnValueUpdate(func(_ *characteristic.Characteristic, v, _ interface{}) {
+		sets <- v.(string)
+	})
+
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	r.tv.ConfiguredName.UpdateValueFromConnection("Kitchen", conn)
+
+	select {
+	case name := <-sets:
+		if name != "Den" {
+			t.Errorf("name reset to %q, want %q", name, "Den")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("name wasn't reset after being renamed in HomeKit")
+	}
+}
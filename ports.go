@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// assignPorts returns the HomeKit port for each device, keyed by serial
+// number.  Devices with a port in the devices config get that one;
+// with -port-base the others get consecutive ports from the base, in
+// order of serial number so that they're stable across restarts.
+// Devices without a port are left out, and get a random one.
+func (cfg *config) assignPorts(devices []discovered) map[string]int {
+	ports := map[string]int{}
+	used := map[int]bool{}
+
+	var serials []string
+	for _, d := range devices {
+		serial := d.deviceInfo.SerialNumber
+		if p := cfg.device(serial).Port; p != 0 {
+			ports[serial] = p
+			used[p] = true
+		} else {
+			serials = append(serials, serial)
+		}
+	}
+
+	if cfg.portBase == 0 {
+		return ports
+	}
+
+	sort.Strings(serials)
+	next := cfg.portBase
+	for _, serial := range serials {
+		for used[next] {
+			next++
+		}
+		ports[serial] = next
+		used[next] = true
+	}
+
+	return ports
+}
+
+// checkPortFree returns an error if nothing can listen on port.
+func checkPortFree(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d out of range", port)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// reachability tracks whether a Roku is answering ECP requests.  A
+// reachable Roku becomes unreachable after failureThreshold
+// consecutive failures, and an unreachable one becomes reachable again
+// after recoveryThreshold consecutive successes, so that a single
+// dropped request on a weak link doesn't flip the state.  With a
+// grace period, the failures must also have gone on for at least that
+// long, which rides out expected outages like firmware updates.
+type reachability struct {
+	mu                sync.Mutex
+	reachable         bool
+	failures          int
+	failingSince      time.Time
+	successes         int
+	failureThreshold  int
+	recoveryThreshold int
+	grace             time.Duration
+
+	// onChange is called, without the lock held, when the state
+	// changes.
+	onChange func(reachable bool)
+}
+
+func newReachability(failureThreshold, recoveryThreshold int, grace time.Duration, onChange func(bool)) *reachability {
+	return &reachability{
+		reachable:         true,
+		failureThreshold:  failureThreshold,
+		recoveryThreshold: recoveryThreshold,
+		grace:             grace,
+		onChange:          onChange,
+	}
+}
+
+// record notes the outcome of an ECP request.
+func (rt *reachability) record(err error) {
+	rt.mu.Lock()
+
+	changed := false
+	if err != nil {
+		rt.successes = 0
+		rt.failures++
+		if rt.failures == 1 {
+			rt.failingSince = time.Now()
+		}
+		if rt.reachable && rt.failures >= rt.failureThreshold && time.Since(rt.failingSince) >= rt.grace {
+			rt.reachable = false
+			changed = true
+		}
+	} else {
+		rt.failures = 0
+		rt.successes++
+		if !rt.reachable && rt.successes >= rt.recoveryThreshold {
+			rt.reachable = true
+			changed = true
+		}
+	}
+
+	reachable := rt.reachable
+	rt.mu.Unlock()
+
+	if changed && rt.onChange != nil {
+		rt.onChange(reachable)
+	}
+}
+
+func (rt *reachability) isReachable() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.reachable
+}
+
+func (rt *reachability) thresholds() (failure, recovery int) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.failureThreshold, rt.recoveryThreshold
+}
+
+func (rt *reachability) setThresholds(failure, recovery int) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.failureThreshold = failure
+	rt.recoveryThreshold = recovery
+}
+
+// setupReachability creates the Roku's reachability tracker.
+// Thresholds changed through the API take precedence over the devices
+// config, which takes precedence over the command-line flags.  The
+// grace period can only come from the latter two.
+func (r *Roku) setupReachability() {
+	failure, recovery := r.global.unreachableAfter, r.global.reachableAfter
+	grace := r.global.unreachableGrace
+	if r.cfg.unreachableGrace > 0 {
+		grace = r.cfg.unreachableGrace
+	}
+	if r.cfg.UnreachableAfter > 0 {
+		failure = r.cfg.UnreachableAfter
+	}
+	if r.cfg.ReachableAfter > 0 {
+		recovery = r.cfg.ReachableAfter
+	}
+
+	r.stateMu.Lock()
+	if s := r.state.Reachability; s != nil {
+		if s.UnreachableAfter > 0 {
+			failure = s.UnreachableAfter
+		}
+		if s.ReachableAfter > 0 {
+			recovery = s.ReachableAfter
+		}
+	}
+	r.stateMu.Unlock()
+
+	r.reach = newReachability(failure, recovery, grace, r.reachabilityChanged)
+}
+
+func (r *Roku) reachabilityChanged(reachable bool) {
+	n, total := r.global.fleet.changed(reachable)
+	if reachable {
+		log.Printf("%q is reachable again (%d of %d Rokus reachable)", r.deviceInfo.UserDeviceName, n, total)
+		// This is called in the middle of a poll, so don't hold it up.
+		go r.reconnected()
+	} else {
+		log.Printf("%q is unreachable (%d of %d Rokus reachable)", r.deviceInfo.UserDeviceName, n, total)
+		r.notifyChange("reachability", "reachable", "unreachable")
+	}
+}
+
+// setReachabilityThresholds changes the thresholds at runtime and saves
+// them, so they survive restarts.
+func (r *Roku) setReachabilityThresholds(failure, recovery int) {
+	r.reach.setThresholds(failure, recovery)
+
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	r.state.Reachability = &reachabilityState{
+		UnreachableAfter: failure,
+		ReachableAfter:   recovery,
+	}
+	r.saveStateLocked()
+}
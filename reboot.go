@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+
+	"github.com/picatz/roku"
+)
+
+// rebootSequence is the remote shortcut that restarts a Roku: Home
+// five times, Up, Rewind twice, then Fast Forward twice.  ECP has no
+// reboot command of its own.
+var rebootSequence = []string{
+	roku.HomeKey, roku.HomeKey, roku.HomeKey, roku.HomeKey, roku.HomeKey,
+	roku.UpKey,
+	roku.RevKey, roku.RevKey,
+	roku.FwdKey, roku.FwdKey,
+}
+
+func (r *Roku) reboot() {
+	log.Printf("Rebooting %q", r.deviceInfo.UserDeviceName)
+
+	if err := r.sendKeys(rebootSequence); err != nil {
+		log.Printf("Reboot of %q failed: %v", r.deviceInfo.UserDeviceName, err)
+		r.audit(auditHomeKit, "reboot", err)
+		return
+	}
+
+	log.Printf("Sent reboot sequence to %q", r.deviceInfo.UserDeviceName)
+	r.audit(auditHomeKit, "reboot", nil)
+}
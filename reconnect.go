@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Actions that can be taken when an unreachable Roku becomes reachable
+// again.
+const (
+	reconnectRefreshApps = "refresh-apps"
+	reconnectPushState   = "push-state"
+	reconnectWebhook     = "webhook"
+)
+
+var reconnectActions = []string{reconnectRefreshApps, reconnectPushState, reconnectWebhook}
+
+// parseReconnectActions parses a comma-separated list of actions to
+// take when a Roku becomes reachable again.
+func parseReconnectActions(s string) (map[string]bool, error) {
+	actions := map[string]bool{}
+	for _, a := range strings.Split(s, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" {
+			continue
+		}
+
+		known := false
+		for _, k := range reconnectActions {
+			if a == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown action %q; must be one of %s", a, strings.Join(reconnectActions, ", "))
+		}
+		actions[a] = true
+	}
+	return actions, nil
+}
+
+// reconnected runs the -on-reconnect actions for a Roku that has just
+// become reachable again.
+func (r *Roku) reconnected() {
+	actions := r.global.onReconnect
+
+	if actions[reconnectWebhook] {
+		r.notifyChange("reachability", "unreachable", "reachable")
+	}
+
+	if actions[reconnectRefreshApps] {
+		r.refreshApps()
+	}
+
+	if actions[reconnectPushState] {
+		// Set these even if they aren't managed, since the Home app's
+		// idea of them may be from before the outage.
+		r.tv.Active.SetValue(r.getActive())
+		r.tv.ActiveIdentifier.SetValue(r.getActiveIdentifier())
+	}
+}
+
+// refreshApps fetches the Roku's app list again, and logs any apps
+// that have been installed or removed since its inputs were set up.
+// HomeKit doesn't pick up new inputs on a published accessory, so those
+// need a restart.
+func (r *Roku) refreshApps() {
+	r.apps.invalidate()
+	apps, err := r.apps.get()
+	if err != nil {
+		log.Printf("Unable to refresh apps for %q: %v", r.deviceInfo.UserDeviceName, err)
+		return
+	}
+
+	current := map[string]bool{homeApp.ID: true}
+	var added []string
+	for _, app := range withDevChannel(r.deviceInfo, apps) {
+		if !r.wantInput(app) {
+			continue
+		}
+		current[app.ID] = true
+		if _, ok := r.appNames[app.ID]; !ok {
+			added = append(added, app.Name)
+		}
+	}
+
+	var removed []string
+	for id, name := range r.appNames {
+		if !current[id] {
+			removed = append(removed, name)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	log.Printf("Apps on %q changed while it was unreachable (added: %s; removed: %s); restart to update its inputs",
+		r.deviceInfo.UserDeviceName, listOrNone(added), listOrNone(removed))
+}
+
+func listOrNone(s []string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// minRediscoverInterval is the shortest time between searches for new
+// Rokus, since each one multicasts to every device on the network.
+const minRediscoverInterval = time.Minute
+
+// rediscoverer periodically searches for Rokus that weren't set up at
+// startup, and calls add for each new one.  A Roku is tried again on
+// later searches until add succeeds.  Endpoints that answered an
+// earlier search but not the device info query are tried again each
+// time, even if they don't answer the search.
+type rediscoverer struct {
+	cfg      *config
+	interval time.Duration
+	add      func(discovered) error
+
+	mu       sync.Mutex
+	scanning bool
+	lastScan time.Time
+	known    map[string]bool // serial numbers already set up
+
+	// pending is keyed by URL.  It's only used while scanning.
+	pending map[string]*ecpEndpoint
+}
+
+func newRediscoverer(cfg *config, interval time.Duration, setUp []discovered, pending []*ecpEndpoint, add func(discovered) error) *rediscoverer {
+	if interval < minRediscoverInterval {
+		log.Printf("Rediscovery interval %v is too short, using %v", interval, minRediscoverInterval)
+		interval = minRediscoverInterval
+	}
+
+	known := map[string]bool{}
+	for _, d := range setUp {
+		known[d.deviceInfo.SerialNumber] = true
+	}
+
+	rd := &rediscoverer{
+		cfg:      cfg,
+		interval: interval,
+		add:      add,
+		lastScan: time.Now(),
+		known:    known,
+		pending:  map[string]*ecpEndpoint{},
+	}
+	for _, e := range pending {
+		rd.pending[e.String()] = e
+	}
+	return rd
+}
+
+// run searches every interval, plus up to a tenth of it again at
+// random so that several instances on a network don't search in step.
+func (rd *rediscoverer) run(ctx context.Context) {
+	for {
+		jitter := time.Duration(rand.Int63n(int64(rd.interval/10) + 1))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rd.interval + jitter):
+			rd.scan()
+		}
+	}
+}
+
+// scan searches for new Rokus, unless a search is already running or
+// the last one was too recent.
+func (rd *rediscoverer) scan() {
+	rd.mu.Lock()
+	if rd.scanning || time.Since(rd.lastScan) < minRediscoverInterval {
+		rd.mu.Unlock()
+		return
+	}
+	rd.scanning = true
+	rd.mu.Unlock()
+
+	defer func() {
+		rd.mu.Lock()
+		rd.scanning = false
+		rd.lastScan = time.Now()
+		rd.mu.Unlock()
+	}()
+
+	found, pending, err := search(rd.cfg)
+	if err != nil {
+		log.Printf("Error searching for new Rokus: %v", err)
+		return
+	}
+
+	// Retry endpoints left pending by earlier searches that didn't
+	// answer this one; this search has already tried the others.
+	answered := map[string]bool{}
+	for _, d := range found {
+		answered[d.endpoint.String()] = true
+	}
+	for _, e := range pending {
+		answered[e.String()] = true
+	}
+	for url, e := range rd.pending {
+		if answered[url] {
+			delete(rd.pending, url)
+			continue
+		}
+
+		deviceInfo, err := rd.cfg.fetchDeviceInfo(e, 1)
+		if err != nil {
+			log.Printf("unable to get device info for %s: %v", e, err)
+			continue
+		}
+
+		delete(rd.pending, url)
+		ep := rd.cfg.withECPPort(e, rd.cfg.device(deviceInfo.SerialNumber).ECPPort)
+		found = append(found, discovered{endpoint: ep, deviceInfo: deviceInfo})
+	}
+	for _, e := range pending {
+		rd.pending[e.String()] = e
+	}
+
+	for _, d := range found {
+		serial := d.deviceInfo.SerialNumber
+
+		rd.mu.Lock()
+		isNew := !rd.known[serial]
+		rd.mu.Unlock()
+		if !isNew {
+			continue
+		}
+
+		log.Printf("Found new Roku %q (%s)", d.deviceInfo.UserDeviceName, serial)
+		if err := rd.add(d); err != nil {
+			log.Printf("Will try %q (%s) again on the next search: %v", d.deviceInfo.UserDeviceName, serial, err)
+			continue
+		}
+
+		rd.mu.Lock()
+		rd.known[serial] = true
+		rd.mu.Unlock()
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/picatz/roku"
+)
+
+// resolveApp finds the app in apps matching query, which may be an app
+// ID or a name.  Names are matched case-insensitively, first exactly
+// and then as a substring.  If a substring matches more than one app,
+// the error lists them.
+func resolveApp(apps roku.Apps, query string) (*roku.App, error) {
+	for _, app := range apps {
+		if app.ID == query {
+			return app, nil
+		}
+	}
+
+	q := strings.ToLower(strings.TrimSpace(query))
+
+	for _, app := range apps {
+		if strings.ToLower(app.Name) == q {
+			return app, nil
+		}
+	}
+
+	var matches []*roku.App
+	for _, app := range apps {
+		if strings.Contains(strings.ToLower(app.Name), q) {
+			matches = append(matches, app)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no app matching %q", query)
+	case 1:
+		return matches[0], nil
+	}
+
+	var candidates []string
+	for _, app := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (%s)", app.Name, app.ID))
+	}
+	sort.Strings(candidates)
+
+	return nil, fmt.Errorf("%q matches more than one app: %s", query, strings.Join(candidates, ", "))
+}
+
+// launch launches the app matching query, which may be an app ID or a
+// name.  It returns the app that was launched.
+func (r *Roku) launch(query string, params map[string]string) (*roku.App, error) {
+	apps, err := r.apps.get()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get apps: %w", err)
+	}
+
+	app, err := resolveApp(apps, query)
+	if err != nil {
+		if isChannelID(query) {
+			return nil, r.notInstalled(query, auditAPI, "open store for "+query)
+		}
+		return nil, err
+	}
+
+	r.launchMu.Lock()
+	err = r.endpoint.LaunchApp(app.ID, params)
+	r.launchMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't launch %s (%s): %w", app.Name, app.ID, err)
+	}
+	r.countLaunch(app.ID)
+
+	return app, nil
+}
+
+// notInstalledError is returned when launching an app by an ID that
+// isn't installed on the Roku.
+type notInstalledError struct {
+	id string
+
+	// installing is true if the Roku was asked to show the app's
+	// channel store page so that it can be installed.
+	installing bool
+}
+
+func (e *notInstalledError) Error() string {
+	if e.installing {
+		return fmt.Sprintf("app %s is not installed; showing its channel store page", e.id)
+	}
+	return fmt.Sprintf("app %s is not installed", e.id)
+}
+
+// isChannelID reports whether s looks like a channel store ID, which
+// are numeric.
+func isChannelID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// notInstalled handles an attempt to launch app ID id, which isn't
+// installed.  With -install-missing-apps the Roku is asked to show the
+// app's page in the channel store, where it can be installed with the
+// remote.  Showing the page is audited as command from source.
+func (r *Roku) notInstalled(id, source, command string) error {
+	if !r.global.installMissingApps {
+		return &notInstalledError{id: id}
+	}
+
+	err := r.endpoint.InstallApp(id)
+	r.audit(source, command, err)
+	if err != nil {
+		return fmt.Errorf("app %s is not installed, and couldn't show its channel store page: %w", id, err)
+	}
+	return &notInstalledError{id: id, installing: true}
+}
+
+// mediaTypes are the values Roku's deep linking accepts for mediaType.
+var mediaTypes = []string{"movie", "episode", "season", "series", "shortFormVideo", "tvSpecial", "live"}
+
+// deepLinkParams returns the launch parameters to deep link to content
+// in an app.  Both or neither of contentID and mediaType must be given.
+func deepLinkParams(contentID, mediaType string) (map[string]string, error) {
+	if contentID == "" && mediaType == "" {
+		return nil, nil
+	}
+	if contentID == "" || mediaType == "" {
+		return nil, fmt.Errorf("contentID and mediaType must be given together")
+	}
+
+	for _, t := range mediaTypes {
+		if t == mediaType {
+			return map[string]string{"contentID": contentID, "mediaType": mediaType}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid mediaType %q; must be one of %s", mediaType, strings.Join(mediaTypes, ", "))
+}
+
+// waitForActiveApp polls the Roku's active app for a few seconds after
+// launching id, since apps take a moment to come up.  The first check
+// is made after the launch verification delay.  It returns the last
+// active app seen, which may not be id if the launch didn't take.
+func (r *Roku) waitForActiveApp(id string) (*roku.App, error) {
+	const (
+		polls    = 5
+		interval = time.Second
+	)
+
+	delay := r.global.launchVerifyDelay
+	if r.cfg.launchVerifyDelay != nil {
+		delay = *r.cfg.launchVerifyDelay
+	}
+
+	var (
+		app *roku.App
+		err error
+	)
+	for i := 0; i < polls; i++ {
+		if i == 0 {
+			time.Sleep(delay)
+		} else {
+			time.Sleep(interval)
+		}
+
+		app, err = r.endpoint.ActiveApp()
+		if err == nil && app.ID == id {
+			break
+		}
+	}
+
+	return app, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// unexpectedResponseError is returned for ECP queries that are answered
+// with something other than XML, such as the HTML error page some Rokus
+// serve when ECP is restricted.  Otherwise the roku package tries to
+// parse the page as XML and reports a confusing syntax error.
+type unexpectedResponseError struct {
+	url         string
+	status      int
+	contentType string
+}
+
+func (e *unexpectedResponseError) Error() string {
+	return fmt.Sprintf("unexpected response from %s: %d %s, content type %q",
+		e.url, e.status, http.StatusText(e.status), e.contentType)
+}
+
+// checkedTransport checks responses to ECP queries before the roku
+// package decodes them.  Other requests, like keypresses and icons,
+// are passed through unchanged.
+type checkedTransport struct {
+	base  http.RoundTripper
+	debug bool
+}
+
+func (t *checkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	path := req.URL.Path
+	if !strings.HasPrefix(path, "/query/") || strings.HasPrefix(path, "/query/icon/") {
+		return resp, nil
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isXML := mediaType == "" || strings.HasSuffix(mediaType, "/xml")
+	if resp.StatusCode == http.StatusOK && isXML {
+		return resp, nil
+	}
+
+	if t.debug {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Printf("Unexpected response body from %s: %s", req.URL, bytes.TrimSpace(b))
+	}
+	resp.Body.Close()
+
+	return nil, &unexpectedResponseError{
+		url:         req.URL.String(),
+		status:      resp.StatusCode,
+		contentType: contentType,
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/picatz/roku"
+)
+
+const (
+	// scanConcurrency is how many hosts are probed at once when
+	// scanning for Rokus.
+	scanConcurrency = 32
+
+	ecpPort = 8060
+)
+
+// parseScanCIDRs parses a comma-separated list of networks to scan for
+// Rokus.
+func parseScanCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -scan-cidr: %w", err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid -scan-cidr %q: only IPv4 networks can be scanned", c)
+		}
+		// Keep a typo from probing millions of addresses.
+		if ones, bits := n.Mask.Size(); bits-ones > 16 {
+			return nil, fmt.Errorf("invalid -scan-cidr %q: networks larger than /16 can't be scanned", c)
+		}
+
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// scanHosts returns the addresses in n, leaving out the network and
+// broadcast addresses of networks that have them.
+func scanHosts(n *net.IPNet) []net.IP {
+	var hosts []net.IP
+	for ip := n.IP.Mask(n.Mask).To4(); n.Contains(ip); ip = nextIP(ip) {
+		hosts = append(hosts, ip)
+	}
+
+	if ones, bits := n.Mask.Size(); bits-ones >= 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// scan probes every address in cfg.scanCIDRs for a Roku answering
+// ECP, for networks where SSDP doesn't get through.  Each host gets
+// cfg.scanTimeout to answer.  Addresses in skip, such as Rokus SSDP
+// already found, aren't probed.
+func scan(cfg *config, skip map[string]bool) []*ecpEndpoint {
+	client := &http.Client{Timeout: cfg.scanTimeout}
+
+	var (
+		mu    sync.Mutex
+		found []*ecpEndpoint
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, scanConcurrency)
+	)
+	for _, n := range cfg.scanCIDRs {
+		log.Printf("Scanning %s for Rokus...", n)
+
+		for _, ip := range scanHosts(n) {
+			if skip[ip.String()] {
+				continue
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(ip net.IP) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				url := fmt.Sprintf("http://%s:%d/", ip, ecpPort)
+				if !probeECP(client, url) {
+					return
+				}
+
+				mu.Lock()
+				found = append(found, cfg.newEndpoint(roku.NewEndpoint(url)))
+				mu.Unlock()
+			}(ip)
+		}
+	}
+	wg.Wait()
+
+	return found
+}
+
+// probeECP reports whether url answers a device info query.
+func probeECP(client *http.Client, url string) bool {
+	resp, err := client.Get(url + "query/device-info")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/brutella/hc"
+)
+
+// readSecret reads a secret, such as the HomeKit PIN, from a file so
+// that it doesn't show up in process listings.  Surrounding whitespace
+// is ignored.
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret: %w", err)
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return s, nil
+}
+
+// uniquePIN derives a HomeKit PIN for the Roku with the given serial
+// number from the shared PIN, so that each accessory has its own.  It's
+// the same every time for the same PIN and serial number, so the
+// accessory doesn't need to be paired again after restarts.
+func uniquePIN(shared, serial string) string {
+	for i := 0; ; i++ {
+		mac := hmac.New(sha256.New, []byte(shared))
+		fmt.Fprintf(mac, "%s/%d", serial, i)
+		sum := mac.Sum(nil)
+
+		pin := fmt.Sprintf("%08d", binary.BigEndian.Uint64(sum)%100000000)
+		// Some PINs, like 12345678, aren't allowed.
+		if _, err := hc.ValidatePin(pin); err == nil {
+			return pin
+		}
+	}
+}
+
+// warnSharedPINs warns when several Rokus use the same PIN, which can
+// confuse the Home app while adding them.
+func warnSharedPINs(rokus []*Roku) {
+	byPIN := map[string]int{}
+	for _, r := range rokus {
+		byPIN[r.hcConfig.Pin]++
+	}
+
+	for _, n := range byPIN {
+		if n > 1 {
+			log.Printf("WARNING: %d Rokus share a HomeKit PIN, which can confuse the Home app while adding them; consider -unique-pins", n)
+		}
+	}
+}
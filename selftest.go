@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// selfTest records the outcome of the most recent self-test probe.
+type selfTest struct {
+	mu      sync.Mutex
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+func (st *selfTest) record(latency time.Duration, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.at = time.Now()
+	st.latency = latency
+	st.err = err
+}
+
+func (st *selfTest) last() (at time.Time, latency time.Duration, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.at, st.latency, st.err
+}
+
+// runSelfTest probes the Roku with a device info query every interval,
+// independently of the regular poll, recording the latency and feeding
+// the result into reachability.
+func (r *Roku) runSelfTest(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.global.pause.isPaused() {
+				continue
+			}
+
+			start := time.Now()
+			_, err := r.endpoint.DeviceInfo()
+			r.selfTest.record(time.Since(start), err)
+			r.reach.record(err)
+		}
+	}
+}
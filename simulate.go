@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/picatz/roku"
+)
+
+// defaultSimulatedApps are installed on simulated Rokus unless
+// -simulate-apps says otherwise.
+var defaultSimulatedApps = roku.Apps{
+	{ID: "12", Name: "Netflix", Type: "appl"},
+	{ID: "837", Name: "YouTube", Type: "appl"},
+	{ID: "13", Name: "Prime Video", Type: "appl"},
+	{ID: "2285", Name: "Hulu", Type: "appl"},
+	{ID: "tvinput.hdmi1", Name: "HDMI 1", Type: "tvin"},
+}
+
+// simulatedRoku is an in-memory stand-in for a Roku, for trying out
+// pairing and the Home app without one.  Commands are logged and
+// change its state the way they would on a Roku TV.
+type simulatedRoku struct {
+	name string
+	apps roku.Apps
+	info roku.DeviceInfo // what DeviceInfo reports, but for power
+
+	mu        sync.Mutex
+	on        bool
+	activeApp string // empty for the home screen
+}
+
+// parseSimulatedApps parses a comma-separated list of apps, each
+// given as ID:Name.
+func parseSimulatedApps(s string) (roku.Apps, error) {
+	var apps roku.Apps
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+
+		parts := strings.SplitN(a, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid simulated app %q (must be ID:Name)", a)
+		}
+
+		typ := "appl"
+		if strings.HasPrefix(parts[0], tvInputPrefix) {
+			typ = "tvin"
+		}
+		apps = append(apps, &roku.App{ID: parts[0], Name: parts[1], Type: typ})
+	}
+	return apps, nil
+}
+
+// simulate returns simulated Rokus with the given comma-separated
+// names, each with apps installed.
+func simulate(names string, apps roku.Apps) []discovered {
+	var found []discovered
+	for i, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		s := &simulatedRoku{
+			name: name,
+			apps: apps,
+			info: roku.DeviceInfo{
+				SerialNumber:      fmt.Sprintf("SIM%09d", i+1),
+				DeviceID:          fmt.Sprintf("SIM%09d", i+1),
+				VendorName:        "Roku",
+				ModelName:         "Simulated Roku TV",
+				ModelNumber:       "SIM",
+				FriendlyModelName: "Simulated Roku TV",
+				UserDeviceName:    name,
+				SoftwareVersion:   "0.0.0",
+				SoftwareBuild:     "0",
+				IsTv:              "true",
+				NetworkType:       "ethernet",
+			},
+			on: true,
+		}
+		deviceInfo, _ := s.DeviceInfo()
+		found = append(found, discovered{endpoint: s, deviceInfo: deviceInfo})
+		log.Printf("Simulating Roku %q", name)
+	}
+	return found
+}
+
+func (s *simulatedRoku) String() string { return "simulated:" + s.name }
+func (s *simulatedRoku) host() string   { return "simulated" }
+
+func (s *simulatedRoku) DeviceInfo() (*roku.DeviceInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := s.info
+	info.PowerMode = "DisplayOff"
+	if s.on {
+		info.PowerMode = "PowerOn"
+	}
+	return &info, nil
+}
+
+func (s *simulatedRoku) Apps() (roku.Apps, error) {
+	return s.apps, nil
+}
+
+func (s *simulatedRoku) ActiveApp() (*roku.App, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, app := range s.apps {
+		if app.ID == s.activeApp {
+			return app, nil
+		}
+	}
+	return &roku.App{Name: "Roku"}, nil
+}
+
+func (s *simulatedRoku) Icon(id string) ([]byte, error) {
+	return nil, fmt.Errorf("simulated Rokus have no icons")
+}
+
+func (s *simulatedRoku) Keypress(key string) error {
+	log.Printf("Simulated %q: keypress %s", s.name, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch key {
+	case powerOnKey:
+		s.on = true
+	case roku.PowerOffKey:
+		s.on = false
+	case "Power":
+		s.on = !s.on
+	case roku.HomeKey:
+		s.activeApp = ""
+	}
+	return nil
+}
+
+func (s *simulatedRoku) FindRemote() error {
+	log.Printf("Simulated %q: finding remote", s.name)
+	return nil
+}
+
+func (s *simulatedRoku) LaunchApp(id string, params map[string]string) error {
+	log.Printf("Simulated %q: launching app %s %v", s.name, id, params)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, app := range s.apps {
+		if app.ID == id {
+			s.activeApp = id
+			return nil
+		}
+	}
+	return roku.ErrAppNotFound
+}
+
+func (s *simulatedRoku) Input(params map[string]string, appID string) error {
+	log.Printf("Simulated %q: input %v to app %q", s.name, params, appID)
+	return nil
+}
+
+func (s *simulatedRoku) InstallApp(id string) error {
+	log.Printf("Simulated %q: showing channel store page for app %s", s.name, id)
+	return nil
+}
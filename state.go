@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stateFile holds changes made to an accessory from the Home app, so
+// that they survive restarts.  It lives alongside the HomeKit pairing
+// data in the device's storage directory.
+const stateFile = "roku-homekit.json"
+
+type deviceState struct {
+	Name   *string                `json:"name,omitempty"`   // the TV's name
+	Inputs map[string]*inputState `json:"inputs,omitempty"` // keyed by app ID
+	Apps   map[string]*appState   `json:"apps,omitempty"`   // keyed by app ID, or by name in old files
+
+	Reachability *reachabilityState `json:"reachability,omitempty"`
+}
+
+// reachabilityState holds reachability thresholds set through the API.
+type reachabilityState struct {
+	UnreachableAfter int `json:"unreachable_after"`
+	ReachableAfter   int `json:"reachable_after"`
+}
+
+// appState records the HomeKit input identifier assigned to an app.
+type appState struct {
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Identifier int    `json:"identifier"`
+}
+
+type inputState struct {
+	Visibility *int    `json:"visibility,omitempty"`
+	Name       *string `json:"name,omitempty"`
+}
+
+func loadDeviceState(dir string) (*deviceState, error) {
+	st := &deviceState{}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+
+	// Older versions kept the Home input's settings under an ID a
+	// channel could also have.  No channel could be added with it
+	// then, so they're the Home input's.
+	if is := st.Inputs[legacyHomeAppID]; is != nil && st.Inputs[homeApp.ID] == nil {
+		st.Inputs[homeApp.ID] = is
+		delete(st.Inputs, legacyHomeAppID)
+	}
+
+	return st, nil
+}
+
+func (st *deviceState) save(dir string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it into place so that a
+	// crash doesn't leave a truncated state file behind.
+	path := filepath.Join(dir, stateFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (st *deviceState) input(appID string) *inputState {
+	if st.Inputs == nil {
+		st.Inputs = map[string]*inputState{}
+	}
+
+	is := st.Inputs[appID]
+	if is == nil {
+		is = &inputState{}
+		st.Inputs[appID] = is
+	}
+
+	return is
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/picatz/roku"
+)
+
+// defaultStorageLayout is the per-device storage directory, relative to
+// the storage path, used by earlier versions of roku-homekit.
+const defaultStorageLayout = "{{.SerialNumber}}"
+
+func parseStorageLayout(layout string) (*template.Template, error) {
+	t, err := template.New("storage-layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage layout %q: %w", layout, err)
+	}
+	return t, nil
+}
+
+// deviceStoragePath returns the directory in which the HomeKit
+// accessory for a device keeps its pairing data.  Each device must have
+// its own directory, so the rendered path has to include one of the
+// device's unique identifiers.
+func deviceStoragePath(cfg *config, deviceInfo *roku.DeviceInfo) (string, error) {
+	var buf bytes.Buffer
+	if err := cfg.storageLayout.Execute(&buf, deviceInfo); err != nil {
+		return "", fmt.Errorf("unable to render storage layout: %w", err)
+	}
+
+	rel := filepath.Clean(buf.String())
+	if rel == "." || rel == "" {
+		return "", errors.New("storage layout rendered an empty path")
+	}
+	if !containsAny(rel, deviceInfo.SerialNumber, deviceInfo.DeviceID, deviceInfo.Udn) {
+		return "", fmt.Errorf("storage layout path %q does not include the device's serial number, device ID or UDN", rel)
+	}
+
+	if filepath.IsAbs(rel) {
+		return rel, nil
+	}
+
+	return filepath.Join(cfg.storagePath, rel), nil
+}
+
+// migrateStorage moves pairing data from the default per-serial
+// directory to path, if the layout has been changed since the device
+// was paired.  Without this the accessory would come up unpaired and
+// have to be re-added to HomeKit.
+func migrateStorage(cfg *config, deviceInfo *roku.DeviceInfo, path string) {
+	legacy := filepath.Join(cfg.storagePath, deviceInfo.SerialNumber)
+	if legacy == path {
+		return
+	}
+
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		log.Printf("Warning: both %s and %s exist for %q; using %s", legacy, path, deviceInfo.UserDeviceName, path)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Warning: unable to migrate storage for %q from %s: %v", deviceInfo.UserDeviceName, legacy, err)
+		return
+	}
+
+	if err := os.Rename(legacy, path); err != nil {
+		log.Printf("Warning: unable to migrate storage for %q from %s: %v", deviceInfo.UserDeviceName, legacy, err)
+		return
+	}
+
+	log.Printf("Migrated storage for %q from %s to %s", deviceInfo.UserDeviceName, legacy, path)
+}
+
+// checkStorage looks for damage to the HomeKit pairing data in dir
+// that hc would otherwise fail on obscurely, or paper over by quietly
+// generating new keys.  A missing directory is fine; it's created when
+// the accessory starts.
+func checkStorage(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		switch {
+		case strings.HasSuffix(e.Name(), ".entity"):
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var v map[string]interface{}
+			if err := json.Unmarshal(b, &v); err != nil {
+				return fmt.Errorf("%s is corrupt: %w", path, err)
+			}
+
+		case e.Name() == "version":
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if _, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); len(b) > 0 && err != nil {
+				return fmt.Errorf("%s is corrupt: %w", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// backupSuffix is added, with a timestamp, to the names of storage
+// directories moved aside by resetStorage.
+const backupSuffix = ".corrupt-"
+
+// resetStorage moves dir aside, so that the accessory starts over with
+// fresh pairing data.  It returns where the old data was moved to.
+func resetStorage(dir string) (string, error) {
+	backup := dir + backupSuffix + time.Now().Format("20060102-150405")
+	if err := os.Rename(dir, backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// isStorageBackup reports whether dir was moved aside by resetStorage.
+func isStorageBackup(dir string) bool {
+	return strings.Contains(filepath.Base(dir), backupSuffix)
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if sub != "" && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
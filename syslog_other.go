@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
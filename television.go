@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/picatz/roku"
+)
+
+// optionalCharacteristics lists the optional television
+// characteristics that can be chosen with -tv-characteristics.  hc
+// adds all of them to the television service; only media state has an
+// ECP equivalent (the Play key), so the rest can be seen and set in
+// HomeKit but don't do anything on the Roku.  Only media state is
+// exposed by default.  Closed captions and picture mode are always
+// removed, since the Home app shows them as settings that would
+// silently do nothing.
+var optionalCharacteristics = []string{
+	"brightness",
+	"display-order",
+	"media-state",
+}
+
+// defaultTVCharacteristics are the optional characteristics that do
+// something on the Roku.
+const defaultTVCharacteristics = "media-state"
+
+func parseTVCharacteristics(s string) (map[string]bool, error) {
+	chars := map[string]bool{}
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+
+		known := false
+		for _, o := range optionalCharacteristics {
+			if c == o {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown television characteristic %q (known: %s)", c, strings.Join(optionalCharacteristics, ", "))
+		}
+
+		chars[c] = true
+	}
+	return chars, nil
+}
+
+func parseSleepDiscoveryMode(s string) (int, error) {
+	switch s {
+	case "always":
+		return characteristic.SleepDiscoveryModeAlwaysDiscoverable, nil
+	case "never":
+		return characteristic.SleepDiscoveryModeNotDiscoverable, nil
+	default:
+		return 0, fmt.Errorf("unknown sleep discovery mode %q (must be always or never)", s)
+	}
+}
+
+// configureTelevision removes the optional characteristics that
+// weren't chosen and wires up the ones that were.
+func (r *Roku) configureTelevision() {
+	chars := map[string]bool{}
+	for c, ok := range r.global.tvCharacteristics {
+		chars[c] = ok
+	}
+	if !r.features[featureMediaState] {
+		chars["media-state"] = false
+	}
+
+	remove := map[*characteristic.Characteristic]bool{
+		r.tv.ClosedCaptions.Characteristic: true,
+		r.tv.PictureMode.Characteristic:    true,
+	}
+	if !chars["brightness"] {
+		remove[r.tv.Brightness.Characteristic] = true
+	}
+	if !chars["display-order"] {
+		remove[r.tv.DisplayOrder.Characteristic] = true
+	}
+	if !chars["media-state"] {
+		remove[r.tv.CurrentMediaState.Characteristic] = true
+		remove[r.tv.TargetMediaState.Characteristic] = true
+	}
+
+	kept := r.tv.Characteristics[:0]
+	for _, c := range r.tv.Characteristics {
+		if !remove[c] {
+			kept = append(kept, c)
+		}
+	}
+	r.tv.Characteristics = kept
+
+	r.tv.SleepDiscoveryMode.SetValue(r.global.sleepDiscoveryMode)
+
+	if chars["media-state"] {
+		r.tv.CurrentMediaState.SetValue(characteristic.CurrentMediaStateUnknown)
+		r.tv.TargetMediaState.OnValueRemoteUpdate(r.setTargetMediaState)
+	}
+
+	r.tv.PowerModeSelection.OnValueRemoteUpdate(r.setPowerModeSelection)
+}
+
+// setPowerModeSelection handles "View TV Settings" in the Home app by
+// sending the -tv-settings-keys to the Roku.  ECP can't open the
+// settings screen directly.
+func (r *Roku) setPowerModeSelection(v int) {
+	if v != characteristic.PowerModeSelectionShow {
+		return
+	}
+
+	keys := r.global.settingsKeys
+	if len(keys) == 0 {
+		log.Printf("Ignoring request to show TV settings on %q (no -tv-settings-keys)", r.deviceInfo.UserDeviceName)
+		return
+	}
+	if r.whilePaused("tv settings", func() { r.setPowerModeSelection(v) }) {
+		return
+	}
+
+	err := r.sendKeys(keys)
+	r.audit(auditHomeKit, "tv settings "+strings.Join(keys, ","), err)
+	if err != nil {
+		log.Printf("Showing TV settings on %q: %v", r.deviceInfo.UserDeviceName, err)
+	}
+}
+
+// setTargetMediaState plays or pauses using the Play key, which
+// toggles between the two.  ECP has no way to stop playback or to
+// query whether something is playing, so stop requests are ignored.
+func (r *Roku) setTargetMediaState(state int) {
+	if state == characteristic.TargetMediaStateStop {
+		return
+	}
+	if r.whilePaused("play/pause", func() { r.setTargetMediaState(state) }) {
+		return
+	}
+
+	err := r.endpoint.Keypress(roku.PlayKey)
+	r.audit(auditHomeKit, "key "+roku.PlayKey, err)
+	if err != nil {
+		log.Printf("Keypress %q on %q: %v", roku.PlayKey, r.deviceInfo.UserDeviceName, err)
+		return
+	}
+
+	r.tv.CurrentMediaState.SetValue(state)
+}
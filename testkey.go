@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// sendTestKey sends a single keypress, already checked with
+// canonicalKey, to the Roku with the given serial number and reports
+// the result.  It returns the process exit code.
+func sendTestKey(found []discovered, serial, key string) int {
+	d, err := selectDevice(found, serial)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	name, serial := d.deviceInfo.UserDeviceName, d.deviceInfo.SerialNumber
+
+	start := time.Now()
+	if err := d.endpoint.Keypress(key); err != nil {
+		fmt.Fprintf(os.Stderr, "Keypress %q on %q (%s) failed: %v\n", key, name, serial, err)
+		return 1
+	}
+
+	fmt.Printf("Keypress %q on %q (%s) succeeded in %v\n", key, name, serial, time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/brutella/hc"
+	hclog "github.com/brutella/hc/log"
+)
+
+// Lifecycle states of a Roku's HomeKit transport.
+const (
+	transportIdle = iota
+	transportRunning
+	transportStopped
+)
+
+// runTransport runs the HomeKit transport until it's stopped.  hc
+// panics if it can't listen on the configured port, which would
+// otherwise take down the whole process; instead the error is logged
+// and, with -retry-random-port, the transport is rebuilt to listen on
+// a port chosen by the OS.  With -restart-transport, a transport that
+// panics for any reason is rebuilt and restarted a few times.
+func (r *Roku) runTransport() {
+	r.transportMu.Lock()
+	if r.transportState == transportStopped {
+		r.transportMu.Unlock()
+		return
+	}
+	r.transportState = transportRunning
+	t := r.transport
+	done := make(chan struct{})
+	r.transportDone = done
+	r.transportMu.Unlock()
+
+	defer close(done)
+
+	err := startTransport(t)
+	if err == nil {
+		return
+	}
+
+	log.Printf("HomeKit transport for %q failed to start on port %q: %v", r.deviceInfo.UserDeviceName, r.hcConfig.Port, err)
+
+	cfg := r.hcConfig
+	if r.global.retryRandomPort && cfg.Port != "" {
+		cfg.Port = ""
+
+		log.Printf("Retrying HomeKit transport for %q on a random port", r.deviceInfo.UserDeviceName)
+		if err = r.restartTransport(cfg); err == nil {
+			return
+		}
+		log.Printf("HomeKit transport for %q failed to start: %v", r.deviceInfo.UserDeviceName, err)
+	}
+
+	for i := 0; r.global.restartTransport && i < transportRestarts; i++ {
+		time.Sleep(transportRestartDelay)
+
+		log.Printf("Restarting HomeKit transport for %q (attempt %d of %d)", r.deviceInfo.UserDeviceName, i+1, transportRestarts)
+		if err = r.restartTransport(cfg); err == nil {
+			return
+		}
+		log.Printf("HomeKit transport for %q failed: %v", r.deviceInfo.UserDeviceName, err)
+	}
+}
+
+const (
+	// transportRestarts is how many times -restart-transport restarts
+	// a transport that panicked.
+	transportRestarts = 5
+
+	// transportRestartDelay is the pause before each restart.
+	transportRestartDelay = 10 * time.Second
+)
+
+// restartTransport rebuilds the transport with cfg and runs it.  It
+// returns nil without starting anything if the transport has been
+// stopped.
+func (r *Roku) restartTransport(cfg hc.Config) error {
+	t, err := hc.NewIPTransport(cfg, r.accessory)
+	if err != nil {
+		return fmt.Errorf("error rebuilding transport: %w", err)
+	}
+
+	// Don't start the new transport if we were stopped while building
+	// it.
+	r.transportMu.Lock()
+	if r.transportState == transportStopped {
+		r.transportMu.Unlock()
+		return nil
+	}
+	r.transport = t
+	r.transportMu.Unlock()
+
+	return startTransport(t)
+}
+
+// startTransport runs t, turning a panic into an error.  The stack is
+// logged with -debug.
+func startTransport(t hc.Transport) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+			hclog.Debug.Printf("Transport panic: %v\n%s", v, debug.Stack())
+		}
+	}()
+
+	t.Start()
+	return nil
+}
+
+// stopTransport stops the HomeKit transport and waits for it to shut
+// down.  It's safe to call before the transport has started, while
+// it's starting, after it failed to start, and more than once; none of
+// these wait on a transport that will never report being stopped.
+func (r *Roku) stopTransport() {
+	r.transportMu.Lock()
+	state := r.transportState
+	r.transportState = transportStopped
+	t, done := r.transport, r.transportDone
+	r.transportMu.Unlock()
+
+	if state != transportRunning || t == nil {
+		return
+	}
+
+	// hc reports that the transport stopped on the channel returned
+	// by Stop, but only if Start returns normally, so also watch for
+	// runTransport finishing.
+	select {
+	case <-t.Stop():
+	case <-done:
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/hc/accessory"
+	"github.com/picatz/roku"
+)
+
+// fakeTransport behaves like hc's IP transport: Start blocks until
+// Stop is called, then reports on the unbuffered channel returned by
+// Stop.
+type fakeTransport struct {
+	once    sync.Once
+	cancel  chan struct{}
+	stopped chan struct{}
+	panics  bool
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		cancel:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (t *fakeTransport) Start() {
+	if t.panics {
+		panic("listen tcp :51826: bind: address already in use")
+	}
+	<-t.cancel
+	t.stopped <- struct{}{}
+}
+
+func (t *fakeTransport) Stop() <-chan struct{} {
+	t.once.Do(func() { close(t.cancel) })
+	return t.stopped
+}
+
+func newTestRoku(t *fakeTransport) *Roku {
+	return &Roku{
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:     &config{},
+		accessory:  accessory.New(accessory.Info{Name: "Test"}, accessory.TypeTelevision),
+		transport:  t,
+	}
+}
+
+// stopWithin fails the test if r.stopTransport doesn't return in time.
+func stopWithin(tb testing.TB, r *Roku) {
+	tb.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		r.stopTransport()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		tb.Fatal("stopTransport hung")
+	}
+}
+
+func TestStartThenImmediateStop(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		r := newTestRoku(newFakeTransport())
+		go r.runTransport()
+		stopWithin(t, r)
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	ft := newFakeTransport()
+	r := newTestRoku(ft)
+
+	stopWithin(t, r)
+
+	// A transport stopped before it started shouldn't start at all.
+	done := make(chan struct{})
+	go func() {
+		r.runTransport()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runTransport started a stopped transport")
+	}
+}
+
+func TestStopAfterFailedStart(t *testing.T) {
+	ft := newFakeTransport()
+	ft.panics = true
+	r := newTestRoku(ft)
+
+	r.runTransport()
+	stopWithin(t, r)
+}
+
+func TestStopTwice(t *testing.T) {
+	r := newTestRoku(newFakeTransport())
+	go r.runTransport()
+
+	stopWithin(t, r)
+	stopWithin(t, r)
+}
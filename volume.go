@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+	"github.com/picatz/roku"
+)
+
+const (
+	// volumeSteps is how many volume key presses take a Roku TV from
+	// silent to full volume.
+	volumeSteps = 100
+
+	// volumeStepInterval is the time between volume key presses,
+	// which Rokus handle much faster than other keys.
+	volumeStepInterval = 100 * time.Millisecond
+)
+
+// volumeProxy shows a Roku TV's volume as the brightness of a
+// lightbulb, since some HomeKit apps have a better slider for that
+// than for a speaker.  ECP can't read the volume, only step it up and
+// down, so the level is an estimate: it's changed by sending one key
+// per step, and drifts if the volume is changed with the remote.
+// Setting it to 0 always sends enough steps to reach silence, which
+// brings the estimate back in line.
+type volumeProxy struct {
+	mu      sync.Mutex
+	level   int // estimated current volume
+	target  int
+	running bool
+
+	// muted is whether the light was last turned off.  Like level,
+	// it's an estimate, since the mute key toggles.
+	muted bool
+}
+
+// addVolumeProxy adds a "Volume" lightbulb whose brightness sets the
+// volume.  Turning the light off mutes the Roku, and turning it on
+// unmutes it.
+func (r *Roku) addVolumeProxy() {
+	lb := service.NewLightbulb()
+
+	name := characteristic.NewName()
+	name.SetValue("Volume")
+	lb.AddCharacteristic(name.Characteristic)
+
+	brightness := characteristic.NewBrightness()
+	lb.AddCharacteristic(brightness.Characteristic)
+	lb.On.SetValue(true)
+
+	brightness.OnValueRemoteUpdate(func(v int) {
+		if r.whilePaused("volume", func() { r.setVolume(v) }) {
+			return
+		}
+		r.setVolume(v)
+	})
+
+	lb.On.OnValueRemoteUpdate(func(on bool) {
+		if r.whilePaused("mute", func() { r.setMuted(!on) }) {
+			return
+		}
+		r.setMuted(!on)
+	})
+
+	r.accessory.AddService(lb.Service)
+}
+
+// setVolume moves the volume toward v in the background.  If it's
+// still moving toward an earlier value, it heads for v instead.
+func (r *Roku) setVolume(v int) {
+	vp := &r.volume
+
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+
+	vp.target = v
+	if v == 0 {
+		// Calibrate, whatever the estimate says.
+		vp.level = volumeSteps
+	}
+	if vp.running {
+		return
+	}
+	vp.running = true
+	go r.rampVolume()
+}
+
+func (r *Roku) rampVolume() {
+	vp := &r.volume
+
+	var err error
+	for {
+		vp.mu.Lock()
+		key := roku.VolumeUpKey
+		switch {
+		case err != nil || vp.level == vp.target:
+			target := vp.target
+			vp.running = false
+			vp.mu.Unlock()
+
+			r.audit(auditHomeKit, "volume", err)
+			if err != nil {
+				log.Printf("Setting volume on %q to %d: %v", r.deviceInfo.UserDeviceName, target, err)
+			}
+			return
+		case vp.level > vp.target:
+			key = roku.VolumeDownKey
+			vp.level--
+		default:
+			vp.level++
+		}
+		vp.mu.Unlock()
+
+		err = r.endpoint.Keypress(key)
+		time.Sleep(volumeStepInterval)
+	}
+}
+
+// setMuted presses the mute key if the Roku isn't already muted, or
+// unmuted, as asked.  The Home app turns the light off when the
+// brightness is dragged to 0 and back on when it's raised, and some
+// apps turn it on with every brightness change, so the light's state
+// can be set to what it already is.
+func (r *Roku) setMuted(muted bool) {
+	vp := &r.volume
+
+	vp.mu.Lock()
+	if vp.muted == muted {
+		vp.mu.Unlock()
+		return
+	}
+	vp.muted = muted
+	vp.mu.Unlock()
+
+	// Don't hold up the volume while the key is sent.
+	err := r.endpoint.Keypress(roku.VolumeMuteKey)
+	r.audit(auditHomeKit, "key "+roku.VolumeMuteKey, err)
+	if err != nil {
+		log.Printf("Keypress %q on %q: %v", roku.VolumeMuteKey, r.deviceInfo.UserDeviceName, err)
+
+		vp.mu.Lock()
+		if vp.muted == muted {
+			vp.muted = !muted
+		}
+		vp.mu.Unlock()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/picatz/roku"
+)
+
+// keyRecorder is a controller that records the keys pressed.
+type keyRecorder struct {
+	fakeRoku
+	keys []string
+}
+
+func (k *keyRecorder) Keypress(key string) error {
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+func TestSetMutedOnlyPressesOnChange(t *testing.T) {
+	k := &keyRecorder{}
+	r := &Roku{
+		endpoint:   k,
+		deviceInfo: &roku.DeviceInfo{UserDeviceName: "Test"},
+		global:     &config{},
+	}
+
+	// On is written alongside brightness changes, as well as on its
+	// own.
+	for _, muted := range []bool{false, true, true, false, false, true} {
+		r.setMuted(muted)
+	}
+
+	if len(k.keys) != 3 {
+		t.Errorf("pressed %v, want mute 3 times", k.keys)
+	}
+	if !r.volume.muted {
+		t.Error("not muted after muting")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// stateChange describes a change in a Roku's state noticed while
+// polling.  It's the JSON payload POSTed to the webhook.
+type stateChange struct {
+	Serial    string    `json:"serial"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"` // "power", "app", "reachability", "find_remote" or "power_on_app"
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// OldName and NewName are the names of the apps in an "app"
+	// change, whose values are app IDs.
+	OldName string `json:"old_name,omitempty"`
+	NewName string `json:"new_name,omitempty"`
+}
+
+// webhook POSTs state changes to a URL.  Changes of the same type on
+// the same device within the debounce window are coalesced, and failed
+// deliveries are retried with exponential backoff.  Coalesced changes
+// that end up where they started, like a quick A -> B -> A, aren't
+// sent at all.
+type webhook struct {
+	url      string
+	debounce time.Duration
+	retries  int
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending map[string]*stateChange
+}
+
+func newWebhook(url string, debounce time.Duration, retries int) *webhook {
+	return &webhook{
+		url:      url,
+		debounce: debounce,
+		retries:  retries,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  map[string]*stateChange{},
+	}
+}
+
+// notify queues a state change for delivery.  It is a no-op on a nil
+// webhook, so callers don't need to check whether one is configured.
+func (w *webhook) notify(c stateChange) {
+	if w == nil {
+		return
+	}
+
+	key := c.Serial + "/" + c.Type
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p := w.pending[key]; p != nil {
+		// Keep the original old value so that a quick A -> B -> C is
+		// reported as A -> C.
+		p.New = c.New
+		p.NewName = c.NewName
+		p.Timestamp = c.Timestamp
+		return
+	}
+
+	w.pending[key] = &c
+	time.AfterFunc(w.debounce, func() { w.flush(key) })
+}
+
+func (w *webhook) flush(key string) {
+	w.mu.Lock()
+	c := w.pending[key]
+	delete(w.pending, key)
+	w.mu.Unlock()
+
+	if c == nil || c.Old == c.New {
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := w.post(c)
+		if err == nil {
+			return
+		}
+
+		if attempt >= w.retries {
+			log.Printf("Giving up on webhook for %q %s change: %v", c.Name, c.Type, err)
+			return
+		}
+
+		log.Printf("Webhook for %q %s change failed, retrying in %v: %v", c.Name, c.Type, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *webhook) post(c *stateChange) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}